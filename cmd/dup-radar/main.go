@@ -1,68 +1,186 @@
 package main
 
-// DupRadar – minimal but functional MVP
-// -------------------------------------
-// - Receives GitHub issues.opened webhooks (HMAC‑SHA256 verified)
-// - Creates an embedding with Vertex AI text‑embedding‑005 (API Key or ADC)
-// - Searches BigQuery Vector Search for similar issues
+// DupRadar – duplicate issue detector for GitHub
+// ----------------------------------------------
+// - Receives GitHub issue webhooks (HMAC‑SHA256 verified): opened and edited issues are
+//   checked, closed/reopened/transferred/deleted issues keep the index in sync
+// - Creates embeddings with Vertex AI text‑embedding‑005 (API Key or ADC)
+//   or any OpenAI-compatible embeddings API (OpenAI, Azure, Ollama, llama.cpp, vLLM)
+// - Searches the configured vector store (BigQuery Vector Search, PostgreSQL with
+//   pgvector, or a local file-backed store) for similar issues
 // - Comments top‑k similar issues if distance below threshold
-// - Stores the new issue vector back into BigQuery
+// - Stores the new issue vector in the same store
+// - Deliveries are queued on disk and retried with backoff; failures go to a dead-letter list
+// - SIGTERM/SIGINT stops accepting webhooks and drains running jobs before exiting
+// - The config file is reloaded when it changes or on SIGHUP
+// - Exposes Prometheus metrics on /metrics and exports OpenTelemetry traces over OTLP/HTTP
+// - Logs structured JSON with log/slog; secrets and issue text are never logged
 //
 // Env vars (see .env.example):
-//   GITHUB_PAT                – Personal access token (classic or fine‑grained)
+//   GITHUB_APP_ID             – GitHub App ID (installation taken from each webhook)
+//   GITHUB_PRIVATE_KEY_PATH   – GitHub App private key (PEM file path or base64)
+//   GITHUB_INSTALLATION_ID    – installation used when a webhook carries none (optional)
+//   GITHUB_PAT                – Personal access token, used when no App is configured
 //   GITHUB_WEBHOOK_SECRET     – same secret as Webhook config
 //   VERTEX_API_KEY            – public API key (or omit to use ADC)
 //   GOOGLE_APPLICATION_CREDENTIALS – ADC JSON (if not using gcloud login)
+//   OPENAI_API_KEY            – key for embedding.provider: openai (optional for local servers)
+//   DATABASE_URL              – PostgreSQL connection string for storage.backend: postgres
+//   DUPRADAR_ADMIN_TOKEN      – enables the dead-letter admin endpoints (optional)
+//   LOG_LEVEL                 – debug, info (default), warn or error
 //
-// Config file: configs/config.yaml (see README)
+// Config file: configs/config.yaml or --config (see README). Settings are layered as
+// defaults → YAML → env (GCP_PROJECT_ID, BQ_DATASET, BQ_TABLE, VERTEX_REGION,
+// EMBEDDING_MODEL, EMBEDDING_PROVIDER, OPENAI_BASE_URL, SIMILARITY_THRESHOLD, TOP_K, PORT, OTEL_EXPORTER_OTLP_ENDPOINT) → flags (run with -h for the list).
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/AobaIwaki123/dup-radar/internal/embedding"
 	"github.com/AobaIwaki123/dup-radar/internal/github"
+	"github.com/AobaIwaki123/dup-radar/internal/logging"
+	"github.com/AobaIwaki123/dup-radar/internal/queue"
 	"github.com/AobaIwaki123/dup-radar/internal/storage"
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
 	"github.com/AobaIwaki123/dup-radar/internal/webhook"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	log.Printf("DEBUG: Starting DupRadar service")
 	_ = godotenv.Load()
-	log.Printf("DEBUG: Environment variables loaded from .env file")
 
-	cfg := config.Load("configs/config.yaml")
-	log.Printf("DEBUG: Configuration loaded successfully")
+	flags, err := config.ParseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Invalid command line", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	if err := logging.Setup(cfg.Logging.Format, cfg.Logging.Level); err != nil {
+		fatal("Logging initialization failed", err)
+	}
+	slog.Info("Starting DupRadar service")
+	slog.Info("Configuration loaded", "path", flags.ConfigPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	flushTraces, err := tracing.Setup(ctx, cfg)
+	if err != nil {
+		fatal("Tracing initialization failed", err)
+	}
 
-	ctx := context.Background()
-	
 	// Initialize clients
-	ghClient := github.NewClient(ctx)
-	bqClient := storage.NewBQClient(ctx, cfg)
-	log.Printf("DEBUG: GitHub and BigQuery clients initialized")
+	ghClient, err := github.NewClient(ctx)
+	if err != nil {
+		fatal("GitHub client initialization failed", err)
+	}
+	embedder, err := embedding.New(cfg)
+	if err != nil {
+		fatal("Embedding provider initialization failed", err)
+	}
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		fatal("Vector store initialization failed", err)
+	}
+	slog.Debug("GitHub client and vector store initialized")
+
+	jobs, err := queue.Open(cfg)
+	if err != nil {
+		fatal("Job queue initialization failed", err)
+	}
 
 	secret := os.Getenv("GITHUB_WEBHOOK_SECRET")
 	if secret == "" {
-		log.Fatal("ERROR: GITHUB_WEBHOOK_SECRET not set")
-	}
-	log.Printf("DEBUG: GitHub webhook secret found (length: %d)", len(secret))
-
-	// Determine port
-	port := cfg.Server.Port
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		log.Printf("DEBUG: Found PORT environment variable: %s", envPort)
-		if p, err := strconv.Atoi(envPort); err == nil {
-			port = p
-			log.Printf("DEBUG: Using port from environment variable: %d", port)
-		} else {
-			log.Printf("DEBUG: Invalid PORT environment variable, using config value: %d", port)
+		fatal("GITHUB_WEBHOOK_SECRET not set", nil)
+	}
+
+	// Reload the configuration when the file changes or on SIGHUP
+	live := config.NewLive(flags, cfg)
+	live.OnReload(func(c *config.Config) {
+		if err := logging.SetLevel(c.Logging.Level); err != nil {
+			slog.Error("Failed to apply log level", "error", err)
+		}
+	})
+	go live.Watch(ctx)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("Received SIGHUP, reloading configuration")
+			_ = live.Reload()
 		}
+	}()
+
+	// Setup and start server (PORT / --port are applied by the config loader)
+	server := webhook.SetupServer(ctx, live, ghClient, embedder, store, jobs, secret, cfg.Server.Port)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", err)
+		}
+	}()
+
+	// Wait for SIGTERM (Cloud Run / Kubernetes) or SIGINT (Ctrl+C)
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	<-sigCtx.Done()
+	stop()
+	shutdown(live.Get(), server, jobs, embedder, store, flushTraces)
+}
+
+// shutdown stops accepting webhooks, drains running jobs within server.shutdown_timeout,
+// saves the embedding cache, closes the vector store and flushes pending trace spans.
+// Jobs still running at the deadline stay queued on disk.
+func shutdown(cfg *config.Config, server *http.Server, jobs *queue.Queue, embedder embedding.Embedder, store storage.VectorStore, flushTraces func(context.Context) error) {
+	timeout := cfg.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	slog.Info("Shutting down", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("HTTP server shutdown failed", "error", err)
 	}
+	if err := jobs.Shutdown(ctx); err != nil {
+		slog.Error("Job queue did not drain, unfinished jobs stay pending", "error", err)
+	}
+	if err := embedder.Close(); err != nil {
+		slog.Error("Failed to save embedding cache", "error", err)
+	}
+	if err := store.Close(); err != nil {
+		slog.Error("Failed to close vector store", "error", err)
+	}
+	// Draining the queue may have used up ctx; give the last spans their own deadline
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancelFlush()
+	if err := flushTraces(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	slog.Info("DupRadar stopped")
+}
+
+// traceFlushTimeout bounds exporting the remaining spans on shutdown
+const traceFlushTimeout = 5 * time.Second
 
-	// Setup and start server
-	server := webhook.SetupServer(cfg, ghClient, bqClient, secret, port)
-	log.Fatal(server.ListenAndServe())
+// fatal logs msg with err and exits
+func fatal(msg string, err error) {
+	if err != nil {
+		slog.Error(msg, "error", err)
+	} else {
+		slog.Error(msg)
+	}
+	os.Exit(1)
 }
@@ -2,20 +2,42 @@
 package config
 
 import (
-	"log"
-	"os"
-
-	"gopkg.in/yaml.v3"
+	"strings"
+	"time"
 )
 
+// Config is the service configuration; see Flags.Load for how it is assembled
 type Config struct {
 	Server struct {
 		Port int    `yaml:"port"`
 		Path string `yaml:"path"`
+		// How long to wait for running jobs on SIGTERM/SIGINT before leaving them queued for the next start
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		// How often the config file is checked for changes (0 disables; SIGHUP always reloads)
+		ReloadInterval time.Duration `yaml:"reload_interval"`
+		ReadTimeout    time.Duration `yaml:"read_timeout"`    // Max time to read a request
+		WriteTimeout   time.Duration `yaml:"write_timeout"`   // Max time to write a response
+		IdleTimeout    time.Duration `yaml:"idle_timeout"`    // Keep-alive connection idle time
+		ReadyCacheTTL  time.Duration `yaml:"ready_cache_ttl"` // How long /readyz reuses its dependency checks
+		// Check the embedding provider in /readyz with a real (billed, uncached) embedding
+		// request instead of an unbilled credentials check
+		ReadyCheckEmbedding bool `yaml:"ready_check_embedding"`
 	}
 	GitHub struct {
-		Similarity float64 `yaml:"similarity_threshold"`
-		TopK       int     `yaml:"top_k"`
+		Similarity float64     `yaml:"similarity_threshold"`
+		TopK       int         `yaml:"top_k"`
+		RepoGroups []RepoGroup `yaml:"repo_groups"` // Repositories whose issues are searched together
+		// Re-run the duplicate check on issues.edited and update DupRadar's comment
+		RecheckOnEdit bool `yaml:"recheck_on_edit"`
+		// How closed issues appear in suggestions: annotate (default), prefer_open or exclude
+		ClosedIssues    string              `yaml:"closed_issues"`
+		CommentLanguage string              `yaml:"comment_language"` // ja (default) or en
+		Labels          []string            `yaml:"labels"`           // Added to issues with similar-issue candidates
+		ExcludeLabels   []string            `yaml:"exclude_labels"`   // Issues with any of these labels are not checked
+		CommentTemplate string              `yaml:"comment_template"` // text/template for the comment body
+		RepoConfigTTL   time.Duration       `yaml:"repo_config_ttl"`  // Cache lifetime of .github/dup-radar.yml; needs a restart
+		Orgs            map[string]Override `yaml:"orgs"`             // Per-organization overrides
+		Repos           map[string]Override `yaml:"repos"`            // Per-repository overrides (owner/repo)
 	}
 	GCP struct {
 		ProjectID      string `yaml:"project_id"`
@@ -23,23 +45,130 @@ type Config struct {
 		BQTable        string `yaml:"bq_table"`
 		Region         string `yaml:"region"`
 		EmbeddingModel string `yaml:"embedding_model"`
+		// Send vector_search.dimensions to Vertex AI as outputDimensionality; by default the
+		// returned vectors are only checked against it
+		SendDimensions bool `yaml:"send_dimensions"`
 		VectorSearch   struct {
 			Distance   string `yaml:"distance_type"` // COSINE, DOT_PRODUCT, or EUCLIDEAN
 			Dimensions int    `yaml:"dimensions"`    // Vector dimensions (e.g., 768)
 		} `yaml:"vector_search"`
 	}
+	Embedding struct {
+		Provider string `yaml:"provider"` // vertexai (default; gcp.region and gcp.embedding_model) or openai
+		// Task type of the incoming issue's search vector and of stored vectors (Vertex AI task types)
+		QueryTaskType    string `yaml:"query_task_type"`    // Default RETRIEVAL_QUERY
+		DocumentTaskType string `yaml:"document_task_type"` // Default RETRIEVAL_DOCUMENT
+		// Send the title of stored issues in the title field instead of prepending it to the body
+		// (Vertex AI, RETRIEVAL_DOCUMENT only; other providers always get title and body as one text)
+		TitleField bool `yaml:"title_field"`
+		OpenAI     struct {
+			// Base URL of an OpenAI-compatible API, e.g. https://api.openai.com/v1 or
+			// http://localhost:11434/v1 (Ollama); /embeddings is appended unless present
+			BaseURL string `yaml:"base_url"`
+			Model   string `yaml:"model"`
+			// Send gcp.vector_search.dimensions as the dimensions parameter (text-embedding-3 models)
+			SendDimensions bool `yaml:"send_dimensions"`
+			// Header carrying OPENAI_API_KEY: Authorization (default, as a bearer token) or api-key (Azure)
+			APIKeyHeader string `yaml:"api_key_header"`
+		} `yaml:"openai"`
+		Batch struct {
+			MaxItems    int `yaml:"max_items"`    // Texts per request (0: provider limit, 250 for Vertex AI, 2048 for OpenAI)
+			MaxTokens   int `yaml:"max_tokens"`   // Estimated tokens per request (0: 20000 for Vertex AI, 300000 for OpenAI)
+			MaxAttempts int `yaml:"max_attempts"` // Attempts per request on rate limits and server errors
+		} `yaml:"batch"`
+		// Long issues are split into overlapping chunks with one stored vector each;
+		// search scores are aggregated back to one score per issue
+		Chunking struct {
+			Size      int    `yaml:"size"`       // Characters per chunk; 0 embeds title and body as one text
+			Overlap   int    `yaml:"overlap"`    // Characters shared by consecutive chunks
+			MaxChunks int    `yaml:"max_chunks"` // Chunks embedded per issue; the rest of the body is dropped
+			Aggregate string `yaml:"aggregate"`  // max (most similar chunk, default) or mean
+		} `yaml:"chunking"`
+		// Cleanup of the title and body before embedding, identical for stored and searched issues
+		Preprocess struct {
+			StripComments       bool   `yaml:"strip_comments"`       // HTML comments left by issue templates
+			StripTemplate       bool   `yaml:"strip_template"`       // Unchecked checkbox lines and sections left empty or "_No response_"
+			CodeBlocks          string `yaml:"code_blocks"`          // summarize (default), keep or drop fenced code blocks and logs
+			CodeBlockLines      int    `yaml:"code_block_lines"`     // Lines kept of a summarized code block
+			StripImages         bool   `yaml:"strip_images"`         // Markdown images and <img> tags
+			StripSignatures     bool   `yaml:"strip_signatures"`     // Email signatures, quoted replies and mail footers
+			NormalizeWhitespace bool   `yaml:"normalize_whitespace"` // Collapse runs of spaces and blank lines
+			TitleWeight         int    `yaml:"title_weight"`         // Times the title is repeated in the embedded text
+		} `yaml:"preprocess"`
+		// Cache of embeddings keyed by provider, model, task type and a hash of the text
+		Cache struct {
+			Size          int           `yaml:"size"`           // Entries kept in memory (LRU); 0 disables the cache
+			Path          string        `yaml:"path"`           // File the cache is loaded from and saved to; empty keeps it in memory only
+			FlushInterval time.Duration `yaml:"flush_interval"` // How often a changed cache is saved (it is also saved on shutdown)
+		} `yaml:"cache"`
+	}
+	Storage struct {
+		Backend string `yaml:"backend"` // bigquery (default), local or postgres
+		Local   struct {
+			Path  string `yaml:"path"`  // Data file for the local store
+			Index string `yaml:"index"` // flat (brute-force, default) or hnsw
+			HNSW  struct {
+				M              int `yaml:"m"`               // Max neighbors per node and layer
+				EfConstruction int `yaml:"ef_construction"` // Candidate list size while building
+				EfSearch       int `yaml:"ef_search"`       // Candidate list size while searching
+			} `yaml:"hnsw"`
+		} `yaml:"local"`
+		Postgres struct {
+			DSN       string `yaml:"dsn"`        // Connection string; DATABASE_URL takes precedence
+			Table     string `yaml:"table"`      // Table name (default: issues_vectors)
+			IndexType string `yaml:"index_type"` // hnsw (default) or ivfflat
+		} `yaml:"postgres"`
+	}
+	Queue struct {
+		Dir            string        `yaml:"dir"`             // Directory for pending and dead-lettered jobs
+		Workers        int           `yaml:"workers"`         // Concurrent job workers
+		MaxAttempts    int           `yaml:"max_attempts"`    // Attempts per pipeline step
+		InitialBackoff time.Duration `yaml:"initial_backoff"` // Delay before the first retry (doubles each time)
+		MaxBackoff     time.Duration `yaml:"max_backoff"`     // Upper bound for the retry delay
+		DedupTTL       time.Duration `yaml:"dedup_ttl"`       // How long accepted delivery IDs and event keys are remembered
+	}
+	Logging struct {
+		Level  string `yaml:"level"`  // debug, info (default), warn or error; applied on reload
+		Format string `yaml:"format"` // json (default) or text
+	}
+	Tracing struct {
+		// OTLP/HTTP collector as host:port or URL (e.g. http://localhost:4318); empty disables tracing
+		Endpoint    string  `yaml:"endpoint"`
+		Insecure    bool    `yaml:"insecure"`     // Send to a host:port endpoint over plain HTTP
+		SampleRatio float64 `yaml:"sample_ratio"` // Fraction of deliveries traced (0–1)
+		ServiceName string  `yaml:"service_name"` // service.name resource attribute
+	}
 }
 
-func Load(path string) *Config {
-	f, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("open config: %v", err)
-	}
-	defer f.Close()
+// RepoGroup is a set of repositories (e.g. a monorepo and its satellites)
+// whose issues are treated as one search space
+type RepoGroup struct {
+	Name  string   `yaml:"name"`
+	Repos []string `yaml:"repos"` // owner/repo
+}
 
-	var c Config
-	if err := yaml.NewDecoder(f).Decode(&c); err != nil {
-		log.Fatalf("parse yaml: %v", err)
+// SearchRepos returns the repositories to search for duplicates of an issue in repo:
+// the repo itself plus every member of the groups it belongs to
+func (c *Config) SearchRepos(repo string) []string {
+	repos := []string{repo}
+	seen := map[string]bool{strings.ToLower(repo): true}
+	for _, g := range c.GitHub.RepoGroups {
+		member := false
+		for _, r := range g.Repos {
+			if strings.EqualFold(r, repo) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			continue
+		}
+		for _, r := range g.Repos {
+			if !seen[strings.ToLower(r)] {
+				seen[strings.ToLower(r)] = true
+				repos = append(repos, r)
+			}
+		}
 	}
-	return &c
+	return repos
 }
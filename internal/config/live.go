@@ -0,0 +1,186 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// restartRequired lists settings that are read once at startup; changing them is logged
+// but only takes effect after a restart
+var restartRequired = []string{"server.port", "server.path", "gcp.project_id", "gcp.region", "gcp.embedding_model", "gcp.send_dimensions", "gcp.bq_", "gcp.vector_search.", "embedding.provider", "embedding.openai.", "embedding.batch.", "embedding.cache.", "storage.", "queue.", "github.repo_config_ttl", "server.reload_interval", "server.read_timeout", "server.write_timeout", "server.idle_timeout", "server.ready_cache_ttl", "server.ready_check_embedding", "logging.format", "tracing."}
+
+// Live holds the running configuration and atomically replaces it on reload
+type Live struct {
+	flags *Flags
+	cur   atomic.Pointer[Config]
+
+	mu       sync.Mutex // Serializes reloads
+	hash     [sha256.Size]byte
+	onReload []func(*Config)
+}
+
+// NewLive wraps the initial configuration loaded from flags
+func NewLive(flags *Flags, initial *Config) *Live {
+	l := &Live{flags: flags}
+	l.cur.Store(initial)
+	if data, err := os.ReadFile(flags.ConfigPath); err == nil {
+		l.hash = sha256.Sum256(data)
+	}
+	return l
+}
+
+// Get returns the current configuration; callers must not modify it
+func (l *Live) Get() *Config {
+	return l.cur.Load()
+}
+
+// OnReload registers fn to be called with the new configuration after each reload that changed it
+func (l *Live) OnReload(fn func(*Config)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onReload = append(l.onReload, fn)
+}
+
+// Reload loads and validates the configuration again and swaps it in.
+// On error the current configuration stays in effect.
+func (l *Live) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if data, err := os.ReadFile(l.flags.ConfigPath); err == nil {
+		l.hash = sha256.Sum256(data)
+	}
+
+	next, err := l.flags.Load()
+	if err != nil {
+		slog.Error("Config reload failed, keeping the current configuration", "path", l.flags.ConfigPath, "error", err)
+		return err
+	}
+	changes := Diff(l.cur.Load(), next)
+	if len(changes) == 0 {
+		slog.Info("Config reloaded, no changes", "path", l.flags.ConfigPath)
+		return nil
+	}
+	l.cur.Store(next)
+	slog.Info("Config reloaded", "path", l.flags.ConfigPath, "changes", changes)
+	for _, fn := range l.onReload {
+		fn(next)
+	}
+	return nil
+}
+
+// Watch polls the configuration file every server.reload_interval and reloads it when its
+// content changes, until ctx is cancelled. A zero interval disables polling.
+func (l *Live) Watch(ctx context.Context) {
+	interval := l.Get().Server.ReloadInterval
+	if interval <= 0 {
+		slog.Info("Config file polling disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		data, err := os.ReadFile(l.flags.ConfigPath)
+		if err != nil {
+			slog.Error("Failed to read config file", "path", l.flags.ConfigPath, "error", err)
+			continue
+		}
+		l.mu.Lock()
+		changed := sha256.Sum256(data) != l.hash
+		l.mu.Unlock()
+		if changed {
+			slog.Info("Config file changed, reloading", "path", l.flags.ConfigPath)
+			_ = l.Reload()
+		}
+	}
+}
+
+// Diff describes the settings that differ between two configurations, one line per
+// setting in yaml key notation. Connection strings are not printed.
+func Diff(old, next *Config) []string {
+	var out []string
+	diffValue("", reflect.ValueOf(*old), reflect.ValueOf(*next), &out)
+	return out
+}
+
+// diffValue recurses into structs and maps and reports other values that are not deeply equal
+func diffValue(path string, a, b reflect.Value, out *[]string) {
+	if a.Kind() == reflect.Map {
+		keys := map[string]reflect.Value{}
+		for _, k := range append(a.MapKeys(), b.MapKeys()...) {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			av, bv := a.MapIndex(keys[name]), b.MapIndex(keys[name])
+			switch {
+			case !av.IsValid():
+				*out = append(*out, fmt.Sprintf("%s.%s: added", path, name))
+			case !bv.IsValid():
+				*out = append(*out, fmt.Sprintf("%s.%s: removed", path, name))
+			default:
+				diffValue(path+"."+name, av, bv, out)
+			}
+		}
+		return
+	}
+	if a.Kind() == reflect.Struct {
+		for i := 0; i < a.NumField(); i++ {
+			f := a.Type().Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValue(name, a.Field(i), b.Field(i), out)
+		}
+		return
+	}
+	if reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return
+	}
+	line := fmt.Sprintf("%s: %s → %s", path, formatValue(a), formatValue(b))
+	if strings.HasSuffix(path, ".dsn") {
+		line = path + ": (changed)"
+	}
+	for _, prefix := range restartRequired {
+		if strings.HasPrefix(path, prefix) {
+			line += " (restart required)"
+			break
+		}
+	}
+	*out = append(*out, line)
+}
+
+// formatValue renders a setting for the diff log
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return "unset"
+		}
+		return formatValue(v.Elem())
+	case reflect.String:
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
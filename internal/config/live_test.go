@@ -0,0 +1,49 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	topK := 5
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"no changes", func(c *Config) {}, nil},
+		{"reloadable setting", func(c *Config) { c.GitHub.TopK = 4 }, []string{"github.top_k: 3 → 4"}},
+		{"port needs a restart", func(c *Config) { c.Server.Port = 9000 }, []string{"server.port: 8080 → 9000 (restart required)"}},
+		{"queue section needs a restart", func(c *Config) { c.Queue.Workers = 8 }, []string{"queue.workers: 0 → 8 (restart required)"}},
+		{"repo config ttl needs a restart", func(c *Config) { c.GitHub.RepoConfigTTL = time.Minute },
+			[]string{"github.repo_config_ttl: 0s → 1m0s (restart required)"}},
+		{"log level is applied on reload", func(c *Config) { c.Logging.Level = "debug" }, []string{`logging.level: "info" → "debug"`}},
+		{"log format needs a restart", func(c *Config) { c.Logging.Format = "text" }, []string{`logging.format: "json" → "text" (restart required)`}},
+		{"dsn is not printed", func(c *Config) { c.Storage.Postgres.DSN = "postgres://user:secret@db/dup" },
+			[]string{"storage.postgres.dsn: (changed) (restart required)"}},
+		{"repo override added", func(c *Config) { c.GitHub.Repos = map[string]Override{"owner/repo": {TopK: &topK}} },
+			[]string{"github.repos.owner/repo: added"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := validConfig()
+			tt.modify(next)
+			if got := Diff(validConfig(), next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffRepoOverrideChange(t *testing.T) {
+	a, b := 3, 5
+	old, next := validConfig(), validConfig()
+	old.GitHub.Repos = map[string]Override{"owner/repo": {TopK: &a}}
+	next.GitHub.Repos = map[string]Override{"owner/repo": {TopK: &b}}
+	want := []string{"github.repos.owner/repo.top_k: 3 → 5"}
+	if got := Diff(old, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the configuration file used when --config is not given
+const DefaultPath = "configs/config.yaml"
+
+// setting is a value that can be overridden by an environment variable and a command-line flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	apply func(c *Config, v string) error
+}
+
+// settings lists the overridable values, applied in this order after the YAML file
+var settings = []setting{
+	{"GCP_PROJECT_ID", "project", "GCP project ID (gcp.project_id)", func(c *Config, v string) error {
+		c.GCP.ProjectID = v
+		return nil
+	}},
+	{"BQ_DATASET", "bq-dataset", "BigQuery dataset (gcp.bq_dataset)", func(c *Config, v string) error {
+		c.GCP.BQDataset = v
+		return nil
+	}},
+	{"BQ_TABLE", "bq-table", "BigQuery table (gcp.bq_table)", func(c *Config, v string) error {
+		c.GCP.BQTable = v
+		return nil
+	}},
+	{"VERTEX_REGION", "region", "Vertex AI region (gcp.region)", func(c *Config, v string) error {
+		c.GCP.Region = v
+		return nil
+	}},
+	{"EMBEDDING_MODEL", "embedding-model", "embedding model (gcp.embedding_model)", func(c *Config, v string) error {
+		c.GCP.EmbeddingModel = v
+		return nil
+	}},
+	{"EMBEDDING_PROVIDER", "embedding-provider", "embedding provider: vertexai or openai (embedding.provider)", func(c *Config, v string) error {
+		c.Embedding.Provider = v
+		return nil
+	}},
+	{"OPENAI_BASE_URL", "openai-base-url", "OpenAI-compatible embeddings API base URL (embedding.openai.base_url)", func(c *Config, v string) error {
+		c.Embedding.OpenAI.BaseURL = v
+		return nil
+	}},
+	{"SIMILARITY_THRESHOLD", "similarity-threshold", "similarity threshold (github.similarity_threshold)", func(c *Config, v string) (err error) {
+		c.GitHub.Similarity, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"TOP_K", "top-k", "number of similar issues to list (github.top_k)", func(c *Config, v string) (err error) {
+		c.GitHub.TopK, err = strconv.Atoi(v)
+		return err
+	}},
+	{"PORT", "port", "HTTP port (server.port)", func(c *Config, v string) (err error) {
+		c.Server.Port, err = strconv.Atoi(v)
+		return err
+	}},
+	{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error (logging.level)", func(c *Config, v string) error {
+		c.Logging.Level = v
+		return nil
+	}},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "otlp-endpoint", "OTLP/HTTP trace collector (tracing.endpoint)", func(c *Config, v string) error {
+		c.Tracing.Endpoint = v
+		return nil
+	}},
+}
+
+// Flags holds the command-line options; only flags given explicitly override the configuration
+type Flags struct {
+	ConfigPath string
+	values     map[string]string // Explicitly set override flags by name
+}
+
+// ParseFlags parses the command line (without the program name)
+func ParseFlags(args []string) (*Flags, error) {
+	fs := flag.NewFlagSet("dup-radar", flag.ContinueOnError)
+	f := &Flags{values: make(map[string]string)}
+	fs.StringVar(&f.ConfigPath, "config", DefaultPath, "path to the YAML configuration file")
+	for _, s := range settings {
+		fs.String(s.flag, "", s.usage+"; overrides $"+s.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		if fl.Name != "config" {
+			f.values[fl.Name] = fl.Value.String()
+		}
+	})
+	return f, nil
+}
+
+// Load builds the configuration from defaults, the YAML file at f.ConfigPath,
+// environment variables and command-line flags (later layers win), then validates it
+func (f *Flags) Load() (*Config, error) {
+	c, err := Load(f.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		if v, ok := f.values[s.flag]; ok {
+			if err := s.apply(c, v); err != nil {
+				return nil, fmt.Errorf("flag --%s: invalid value %q: %w", s.flag, v, err)
+			}
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads the YAML file at path on top of the defaults and applies environment
+// variable overrides. Unknown keys are rejected. The result is not validated.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open config: %w", err)
+	}
+
+	c := defaults()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.apply(c, v); err != nil {
+				return nil, fmt.Errorf("env %s: invalid value %q: %w", s.env, v, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// defaults returns the configuration used for keys missing from the YAML file
+func defaults() *Config {
+	var c Config
+	c.Server.Port = 8080
+	c.Server.Path = "/webhook"
+	c.Server.ShutdownTimeout = 10 * time.Second
+	c.Server.ReloadInterval = 10 * time.Second
+	c.Server.ReadTimeout = 10 * time.Second
+	c.Server.WriteTimeout = 30 * time.Second
+	c.Server.IdleTimeout = 2 * time.Minute
+	c.Server.ReadyCacheTTL = 30 * time.Second
+	c.GitHub.Similarity = 0.20
+	c.GitHub.TopK = 3
+	c.GitHub.ClosedIssues = "annotate"
+	c.GitHub.CommentLanguage = "ja"
+	c.GCP.Region = "us-central1"
+	c.GCP.EmbeddingModel = "text-embedding-005"
+	c.GCP.VectorSearch.Distance = "COSINE"
+	c.Embedding.Provider = "vertexai"
+	c.Embedding.QueryTaskType = "RETRIEVAL_QUERY"
+	c.Embedding.DocumentTaskType = "RETRIEVAL_DOCUMENT"
+	c.Embedding.TitleField = true
+	c.Embedding.Chunking.Size = 2000
+	c.Embedding.Chunking.Overlap = 200
+	c.Embedding.Chunking.MaxChunks = 8
+	c.Embedding.Chunking.Aggregate = "max"
+	c.Embedding.Preprocess.StripComments = true
+	c.Embedding.Preprocess.StripTemplate = true
+	c.Embedding.Preprocess.CodeBlocks = "summarize"
+	c.Embedding.Preprocess.CodeBlockLines = 10
+	c.Embedding.Preprocess.StripImages = true
+	c.Embedding.Preprocess.StripSignatures = true
+	c.Embedding.Preprocess.NormalizeWhitespace = true
+	c.Embedding.Preprocess.TitleWeight = 1
+	c.Embedding.Cache.Size = 10000
+	c.Embedding.Cache.FlushInterval = 5 * time.Minute
+	c.Embedding.OpenAI.BaseURL = "https://api.openai.com/v1"
+	c.Embedding.Batch.MaxAttempts = 3
+	c.Storage.Backend = "bigquery"
+	c.Logging.Level = "info"
+	c.Logging.Format = "json"
+	c.Tracing.SampleRatio = 1
+	c.Tracing.ServiceName = "dup-radar"
+	return &c
+}
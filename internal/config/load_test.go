@@ -0,0 +1,104 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// baseYAML holds the settings the defaults leave empty but validation requires
+const baseYAML = `
+gcp:
+  project_id: proj
+  bq_dataset: ds
+  bq_table: issues
+`
+
+// writeConfig writes a configuration file and clears every override variable
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	for _, s := range settings {
+		t.Setenv(s.env, "")
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load parses args after --config and loads the configuration
+func load(t *testing.T, path string, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	f, err := ParseFlags(append([]string{"--config", path}, args...))
+	if err != nil {
+		return nil, err
+	}
+	return f.Load()
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		env      map[string]string
+		args     []string
+		wantPort int
+		wantTopK int
+	}{
+		{"defaults", "", nil, nil, 8080, 3},
+		{"yaml over defaults", "server:\n  port: 9000\n", nil, nil, 9000, 3},
+		{"env over yaml", "server:\n  port: 9000\n", map[string]string{"PORT": "9100"}, nil, 9100, 3},
+		{"flag over env", "server:\n  port: 9000\n", map[string]string{"PORT": "9100"}, []string{"--port", "9200"}, 9200, 3},
+		{"layers apply per setting", "github:\n  top_k: 5\n", map[string]string{"PORT": "9100"}, []string{"--top-k", "7"}, 9100, 7},
+		{"empty env is ignored", "server:\n  port: 9000\n", map[string]string{"PORT": ""}, nil, 9000, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := load(t, writeConfig(t, baseYAML+tt.yaml), tt.env, tt.args...)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if c.Server.Port != tt.wantPort || c.GitHub.TopK != tt.wantTopK {
+				t.Errorf("port, top_k = %d, %d, want %d, %d", c.Server.Port, c.GitHub.TopK, tt.wantPort, tt.wantTopK)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"unknown key", "server:\n  prot: 9000\n", nil, nil, "field prot not found"},
+		{"unknown section", "srever:\n  port: 9000\n", nil, nil, "field srever not found"},
+		{"wrong yaml type", "server:\n  port: eighty\n", nil, nil, "parse"},
+		{"invalid env value", "", map[string]string{"TOP_K": "three"}, nil, "env TOP_K"},
+		{"invalid flag value", "", nil, []string{"--similarity-threshold", "high"}, "flag --similarity-threshold"},
+		{"unknown flag", "", nil, []string{"--prot", "1"}, "prot"},
+		{"fails validation", "storage:\n  backend: sqlite\n", nil, nil, "storage.backend"},
+		{"env value fails validation", "", map[string]string{"PORT": "70000"}, nil, "server.port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, writeConfig(t, baseYAML+tt.yaml), tt.env, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Load of a missing file succeeded")
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoFilePath is the in-repository configuration file read from the default branch
+const RepoFilePath = ".github/dup-radar.yml"
+
+// Override adjusts the github settings for one organization or repository.
+// Unset fields inherit from the enclosing level (global → org → repo).
+type Override struct {
+	Enabled         *bool    `yaml:"enabled"`
+	Similarity      *float64 `yaml:"similarity_threshold"`
+	TopK            *int     `yaml:"top_k"`
+	CommentLanguage string   `yaml:"comment_language"`
+	Labels          []string `yaml:"labels"`         // An empty list removes inherited labels
+	ExcludeLabels   []string `yaml:"exclude_labels"` // An empty list removes inherited labels
+	CommentTemplate string   `yaml:"comment_template"`
+	SearchRepos     []string `yaml:"search_repos"` // Replaces repo_groups as the search scope
+}
+
+// RepoFile is the subset of settings that maintainers may change in .github/dup-radar.yml.
+// The search scope is deliberately absent so a repository cannot read other repositories' issues.
+type RepoFile struct {
+	Enabled         *bool    `yaml:"enabled"` // false opts the repository out
+	Similarity      *float64 `yaml:"similarity_threshold"`
+	TopK            *int     `yaml:"top_k"`
+	ExcludeLabels   []string `yaml:"exclude_labels"`
+	CommentTemplate string   `yaml:"comment_template"`
+}
+
+// ParseRepoFile decodes .github/dup-radar.yml, rejecting unknown keys and values the
+// server settings would reject; distance is gcp.vector_search.distance_type
+func ParseRepoFile(data []byte, distance string) (*RepoFile, error) {
+	var f RepoFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse %s: %w", RepoFilePath, err)
+	}
+	if f.TopK != nil && *f.TopK <= 0 {
+		return nil, fmt.Errorf("parse %s: top_k must be positive", RepoFilePath)
+	}
+	if f.Similarity != nil {
+		if err := validateThreshold(distance, *f.Similarity); err != nil {
+			return nil, fmt.Errorf("parse %s: similarity_threshold %w", RepoFilePath, err)
+		}
+	}
+	return &f, nil
+}
+
+// RepoSettings are the effective github settings for one repository
+type RepoSettings struct {
+	Enabled         bool
+	Similarity      float64
+	TopK            int
+	CommentLanguage string   // "ja" or "en"
+	Labels          []string // Labels added to issues that receive a similar-issues comment
+	ExcludeLabels   []string // Issues carrying any of these labels are not checked
+	CommentTemplate string   // text/template for the comment body; empty uses the built-in layout
+	SearchRepos     []string // Repositories searched for duplicates, starting with the repo itself
+}
+
+// WithRepoFile applies a repository's own .github/dup-radar.yml on top of the server settings.
+// The file can opt a repository out but cannot enable one the server disabled.
+func (rs RepoSettings) WithRepoFile(f *RepoFile) RepoSettings {
+	if f == nil {
+		return rs
+	}
+	if f.Enabled != nil && !*f.Enabled {
+		rs.Enabled = false
+	}
+	if f.Similarity != nil {
+		rs.Similarity = *f.Similarity
+	}
+	if f.TopK != nil {
+		rs.TopK = *f.TopK
+	}
+	if f.ExcludeLabels != nil {
+		rs.ExcludeLabels = f.ExcludeLabels
+	}
+	if f.CommentTemplate != "" {
+		rs.CommentTemplate = f.CommentTemplate
+	}
+	return rs
+}
+
+// Excludes reports whether an issue with the given labels must be skipped
+func (rs RepoSettings) Excludes(labels []string) bool {
+	for _, l := range labels {
+		for _, ex := range rs.ExcludeLabels {
+			if strings.EqualFold(l, ex) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ForRepo resolves the settings for repo (owner/name) by applying the org
+// override and then the repo override on top of the global github settings
+func (c *Config) ForRepo(repo string) RepoSettings {
+	rs := RepoSettings{
+		Enabled:         true,
+		Similarity:      c.GitHub.Similarity,
+		TopK:            c.GitHub.TopK,
+		CommentLanguage: c.GitHub.CommentLanguage,
+		Labels:          c.GitHub.Labels,
+		ExcludeLabels:   c.GitHub.ExcludeLabels,
+		CommentTemplate: c.GitHub.CommentTemplate,
+	}
+	var searchRepos []string
+	org, _, _ := strings.Cut(repo, "/")
+	for _, o := range []*Override{lookup(c.GitHub.Orgs, org), lookup(c.GitHub.Repos, repo)} {
+		if o == nil {
+			continue
+		}
+		if o.Enabled != nil {
+			rs.Enabled = *o.Enabled
+		}
+		if o.Similarity != nil {
+			rs.Similarity = *o.Similarity
+		}
+		if o.TopK != nil {
+			rs.TopK = *o.TopK
+		}
+		if o.CommentLanguage != "" {
+			rs.CommentLanguage = o.CommentLanguage
+		}
+		if o.Labels != nil {
+			rs.Labels = o.Labels
+		}
+		if o.ExcludeLabels != nil {
+			rs.ExcludeLabels = o.ExcludeLabels
+		}
+		if o.CommentTemplate != "" {
+			rs.CommentTemplate = o.CommentTemplate
+		}
+		if o.SearchRepos != nil {
+			searchRepos = o.SearchRepos
+		}
+	}
+	if rs.CommentLanguage == "" {
+		rs.CommentLanguage = "ja"
+	}
+
+	if searchRepos == nil {
+		rs.SearchRepos = c.SearchRepos(repo)
+		return rs
+	}
+	rs.SearchRepos = []string{repo}
+	seen := map[string]bool{strings.ToLower(repo): true}
+	for _, r := range searchRepos {
+		if !seen[strings.ToLower(r)] {
+			seen[strings.ToLower(r)] = true
+			rs.SearchRepos = append(rs.SearchRepos, r)
+		}
+	}
+	return rs
+}
+
+// lookup finds an override by case-insensitive name, as GitHub names are case-insensitive
+func lookup(overrides map[string]Override, name string) *Override {
+	for k, o := range overrides {
+		if strings.EqualFold(k, name) {
+			return &o
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForRepo(t *testing.T) {
+	f := false
+	orgTopK, repoTopK := 5, 7
+	orgThreshold := 0.3
+	c := validConfig()
+	c.GitHub.Labels = []string{"duplicate?"}
+	c.GitHub.RepoGroups = []RepoGroup{{Name: "app", Repos: []string{"acme/api", "acme/web"}}}
+	c.GitHub.Orgs = map[string]Override{
+		"Acme": {TopK: &orgTopK, Similarity: &orgThreshold, CommentLanguage: "en"},
+	}
+	c.GitHub.Repos = map[string]Override{
+		"acme/web":  {TopK: &repoTopK, Labels: []string{}, SearchRepos: []string{"acme/api", "ACME/web", "acme/docs"}},
+		"acme/off":  {Enabled: &f},
+		"other/lib": {CommentLanguage: "en"},
+	}
+
+	tests := []struct {
+		repo string
+		want RepoSettings
+	}{
+		{"solo/repo", RepoSettings{Enabled: true, Similarity: 0.2, TopK: 3, CommentLanguage: "ja",
+			Labels: []string{"duplicate?"}, SearchRepos: []string{"solo/repo"}}},
+		// The org override applies to every repository of the org, matched case-insensitively
+		{"acme/api", RepoSettings{Enabled: true, Similarity: 0.3, TopK: 5, CommentLanguage: "en",
+			Labels: []string{"duplicate?"}, SearchRepos: []string{"acme/api", "acme/web"}}},
+		// The repo override wins over the org; an empty list clears inherited labels
+		// and search_repos replaces the group, always starting with the repo itself
+		{"acme/web", RepoSettings{Enabled: true, Similarity: 0.3, TopK: 7, CommentLanguage: "en",
+			Labels: []string{}, SearchRepos: []string{"acme/web", "acme/api", "acme/docs"}}},
+		{"acme/off", RepoSettings{Enabled: false, Similarity: 0.3, TopK: 5, CommentLanguage: "en",
+			Labels: []string{"duplicate?"}, SearchRepos: []string{"acme/off"}}},
+		{"Other/Lib", RepoSettings{Enabled: true, Similarity: 0.2, TopK: 3, CommentLanguage: "en",
+			Labels: []string{"duplicate?"}, SearchRepos: []string{"Other/Lib"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			if got := c.ForRepo(tt.repo); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ForRepo(%s) = %+v, want %+v", tt.repo, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRepoFile(t *testing.T) {
+	base := RepoSettings{Enabled: true, Similarity: 0.2, TopK: 3, ExcludeLabels: []string{"wontfix"}}
+	tests := []struct {
+		name string
+		yaml string
+		want RepoSettings
+	}{
+		{"empty file", "", base},
+		{"overrides", "similarity_threshold: 0.1\ntop_k: 1\nexclude_labels: []\n",
+			RepoSettings{Enabled: true, Similarity: 0.1, TopK: 1, ExcludeLabels: []string{}}},
+		{"opt out", "enabled: false\n", RepoSettings{Enabled: false, Similarity: 0.2, TopK: 3, ExcludeLabels: []string{"wontfix"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseRepoFile([]byte(tt.yaml), "COSINE")
+			if err != nil {
+				t.Fatalf("ParseRepoFile: %v", err)
+			}
+			if got := base.WithRepoFile(f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WithRepoFile = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// A repository cannot enable itself when the server disabled it
+	disabled := base
+	disabled.Enabled = false
+	f, _ := ParseRepoFile([]byte("enabled: true\n"), "COSINE")
+	if disabled.WithRepoFile(f).Enabled {
+		t.Error("repository file re-enabled a repository the server disabled")
+	}
+}
+
+func TestParseRepoFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		distance string
+		wantErr  string
+	}{
+		{"search scope is not allowed", "search_repos: [other/secret]\n", "COSINE", "field search_repos not found"},
+		{"unknown key", "top-k: 3\n", "COSINE", "field top-k not found"},
+		{"zero top_k", "top_k: 0\n", "COSINE", "top_k"},
+		{"threshold out of range", "similarity_threshold: 3\n", "COSINE", "similarity_threshold"},
+		{"negative euclidean threshold", "similarity_threshold: -1\n", "EUCLIDEAN", "similarity_threshold"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRepoFile([]byte(tt.yaml), tt.distance)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseRepoFile error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
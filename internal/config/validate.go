@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Allowed values of enumerated settings (compared case-insensitively)
+var (
+	distanceTypes   = []string{"COSINE", "DOT_PRODUCT", "EUCLIDEAN"}
+	storageBackends = []string{"bigquery", "local", "postgres"}
+	embedProviders  = []string{"vertexai", "openai"}
+	aggregations    = []string{"max", "mean"}
+	codeBlockModes  = []string{"summarize", "keep", "drop"}
+	taskTypes       = []string{"RETRIEVAL_QUERY", "RETRIEVAL_DOCUMENT", "SEMANTIC_SIMILARITY", "CLASSIFICATION", "CLUSTERING", "QUESTION_ANSWERING", "FACT_VERIFICATION", "CODE_RETRIEVAL_QUERY"}
+	closedModes     = []string{"annotate", "prefer_open", "exclude"}
+	commentLangs    = []string{"ja", "en"}
+	logLevels       = []string{"debug", "info", "warn", "error"}
+	logFormats      = []string{"json", "text"}
+	reservedPaths   = []string{"/healthz", "/readyz", "/version", "/metrics", "/admin/dead-letters", "/admin/dead-letters/replay"}
+)
+
+// Validate checks the configuration and reports every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(strings.HasPrefix(c.Server.Path, "/"), "server.path must start with /, got %q", c.Server.Path)
+	check(!oneOf(c.Server.Path, reservedPaths), "server.path must not be one of %v", reservedPaths)
+	check(c.Server.ShutdownTimeout >= 0, "server.shutdown_timeout must not be negative")
+	check(c.Server.ReadTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0,
+		"server.read_timeout, write_timeout and idle_timeout must not be negative")
+
+	check(oneOf(c.GCP.VectorSearch.Distance, distanceTypes), "gcp.vector_search.distance_type must be one of %v, got %q", distanceTypes, c.GCP.VectorSearch.Distance)
+	check(c.GCP.VectorSearch.Dimensions >= 0, "gcp.vector_search.dimensions must not be negative")
+	check(oneOf(c.Embedding.Provider, embedProviders), "embedding.provider must be one of %v, got %q", embedProviders, c.Embedding.Provider)
+	check(oneOf(c.Embedding.QueryTaskType, taskTypes), "embedding.query_task_type must be one of %v, got %q", taskTypes, c.Embedding.QueryTaskType)
+	check(oneOf(c.Embedding.DocumentTaskType, taskTypes), "embedding.document_task_type must be one of %v, got %q", taskTypes, c.Embedding.DocumentTaskType)
+	switch strings.ToLower(c.Embedding.Provider) {
+	case "vertexai":
+		check(!c.Embedding.TitleField || strings.EqualFold(c.Embedding.DocumentTaskType, "RETRIEVAL_DOCUMENT"),
+			"embedding.title_field requires embedding.document_task_type RETRIEVAL_DOCUMENT, got %q", c.Embedding.DocumentTaskType)
+		check(c.GCP.ProjectID != "", "gcp.project_id must be set for the vertexai embedding provider")
+		check(c.GCP.Region != "", "gcp.region must be set for the vertexai embedding provider")
+		check(c.GCP.EmbeddingModel != "", "gcp.embedding_model must be set for the vertexai embedding provider")
+	case "openai":
+		check(c.Embedding.OpenAI.BaseURL != "", "embedding.openai.base_url must be set for the openai embedding provider")
+		check(c.Embedding.OpenAI.Model != "", "embedding.openai.model must be set for the openai embedding provider")
+	}
+	check(c.Embedding.Batch.MaxItems >= 0 && c.Embedding.Batch.MaxTokens >= 0, "embedding.batch.max_items and max_tokens must not be negative")
+	check(c.Embedding.Batch.MaxAttempts > 0, "embedding.batch.max_attempts must be greater than 0, got %d", c.Embedding.Batch.MaxAttempts)
+	ch := c.Embedding.Chunking
+	check(ch.Size >= 0, "embedding.chunking.size must not be negative, got %d", ch.Size)
+	check(ch.Overlap >= 0 && (ch.Size == 0 || ch.Overlap < ch.Size), "embedding.chunking.overlap must be between 0 and size, got %d", ch.Overlap)
+	check(ch.MaxChunks > 0, "embedding.chunking.max_chunks must be greater than 0, got %d", ch.MaxChunks)
+	check(oneOf(ch.Aggregate, aggregations), "embedding.chunking.aggregate must be one of %v, got %q", aggregations, ch.Aggregate)
+	pp := c.Embedding.Preprocess
+	check(oneOf(pp.CodeBlocks, codeBlockModes), "embedding.preprocess.code_blocks must be one of %v, got %q", codeBlockModes, pp.CodeBlocks)
+	check(pp.CodeBlockLines >= 0, "embedding.preprocess.code_block_lines must not be negative, got %d", pp.CodeBlockLines)
+	check(pp.TitleWeight > 0, "embedding.preprocess.title_weight must be greater than 0, got %d", pp.TitleWeight)
+	check(c.Embedding.Cache.Size >= 0, "embedding.cache.size must not be negative, got %d", c.Embedding.Cache.Size)
+	check(c.Embedding.Cache.Path == "" || c.Embedding.Cache.FlushInterval > 0, "embedding.cache.flush_interval must be greater than 0, got %s", c.Embedding.Cache.FlushInterval)
+	if strings.EqualFold(c.Storage.Backend, "bigquery") {
+		check(c.GCP.ProjectID != "", "gcp.project_id must be set for the bigquery backend")
+		check(c.GCP.BQDataset != "" && c.GCP.BQTable != "", "gcp.bq_dataset and gcp.bq_table must be set for the bigquery backend")
+	}
+	check(oneOf(c.Storage.Backend, storageBackends), "storage.backend must be one of %v, got %q", storageBackends, c.Storage.Backend)
+
+	// Zero queue values fall back to the built-in defaults
+	q := c.Queue
+	check(q.Workers >= 0, "queue.workers must not be negative, got %d", q.Workers)
+	check(q.MaxAttempts >= 0, "queue.max_attempts must not be negative, got %d", q.MaxAttempts)
+	check(q.InitialBackoff >= 0 && q.MaxBackoff >= 0, "queue.initial_backoff and max_backoff must not be negative")
+	check(q.InitialBackoff == 0 || q.MaxBackoff == 0 || q.InitialBackoff <= q.MaxBackoff,
+		"queue.initial_backoff must not exceed queue.max_backoff, got %s > %s", q.InitialBackoff, q.MaxBackoff)
+	check(q.DedupTTL >= 0, "queue.dedup_ttl must not be negative, got %s", q.DedupTTL)
+
+	check(oneOf(c.Logging.Level, logLevels), "logging.level must be one of %v, got %q", logLevels, c.Logging.Level)
+	check(oneOf(c.Logging.Format, logFormats), "logging.format must be one of %v, got %q", logFormats, c.Logging.Format)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	check(c.Tracing.ServiceName != "", "tracing.service_name must be set")
+
+	check(oneOf(c.GitHub.ClosedIssues, closedModes), "github.closed_issues must be one of %v, got %q", closedModes, c.GitHub.ClosedIssues)
+	errs = append(errs, c.validateRepoSettings("github", &Override{
+		Similarity:      &c.GitHub.Similarity,
+		TopK:            &c.GitHub.TopK,
+		CommentLanguage: c.GitHub.CommentLanguage,
+	})...)
+	for name, o := range c.GitHub.Orgs {
+		errs = append(errs, c.validateRepoSettings("github.orgs."+name, &o)...)
+	}
+	for name, o := range c.GitHub.Repos {
+		check(strings.Count(name, "/") == 1, "github.repos: %q is not owner/repo", name)
+		errs = append(errs, c.validateRepoSettings("github.repos."+name, &o)...)
+	}
+	return errors.Join(errs...)
+}
+
+// validateRepoSettings checks the threshold, top_k and comment language of one settings level
+func (c *Config) validateRepoSettings(prefix string, o *Override) []error {
+	var errs []error
+	if o.Similarity != nil {
+		if err := validateThreshold(c.GCP.VectorSearch.Distance, *o.Similarity); err != nil {
+			errs = append(errs, fmt.Errorf("%s.similarity_threshold %w", prefix, err))
+		}
+	}
+	if o.TopK != nil && *o.TopK <= 0 {
+		errs = append(errs, fmt.Errorf("%s.top_k must be greater than 0, got %d", prefix, *o.TopK))
+	}
+	if o.CommentLanguage != "" && !oneOf(o.CommentLanguage, commentLangs) {
+		errs = append(errs, fmt.Errorf("%s.comment_language must be one of %v, got %q", prefix, commentLangs, o.CommentLanguage))
+	}
+	return errs
+}
+
+// validateThreshold checks a similarity threshold against the range of the distance metric
+func validateThreshold(distance string, t float64) error {
+	switch {
+	case math.IsNaN(t) || math.IsInf(t, 0):
+		return fmt.Errorf("must be a finite number, got %v", t)
+	case strings.EqualFold(distance, "COSINE") && (t < 0 || t > 2):
+		return fmt.Errorf("must be between 0 and 2 for COSINE distance, got %v", t)
+	case strings.EqualFold(distance, "EUCLIDEAN") && t < 0:
+		return fmt.Errorf("must not be negative for EUCLIDEAN distance, got %v", t)
+	}
+	return nil
+}
+
+// oneOf reports whether v case-insensitively equals one of allowed
+func oneOf(v string, allowed []string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(v, a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns defaults that pass validation
+func validConfig() *Config {
+	c := defaults()
+	c.GCP.ProjectID = "proj"
+	c.GCP.BQDataset = "ds"
+	c.GCP.BQTable = "issues"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	threshold := 3.0
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"backoff with default max", func(c *Config) { c.Queue.InitialBackoff = time.Hour }, ""},
+		{"port out of range", func(c *Config) { c.Server.Port = 0 }, "server.port"},
+		{"reserved path", func(c *Config) { c.Server.Path = "/healthz" }, "server.path"},
+		{"unknown distance", func(c *Config) { c.GCP.VectorSearch.Distance = "MANHATTAN" }, "distance_type"},
+		{"enum is case-insensitive", func(c *Config) { c.GCP.VectorSearch.Distance = "cosine" }, ""},
+		{"cosine threshold above 2", func(c *Config) { c.GitHub.Similarity = 2.5 }, "github.similarity_threshold"},
+		{"NaN threshold", func(c *Config) { c.GitHub.Similarity = math.NaN() }, "finite"},
+		{"repo override threshold", func(c *Config) {
+			c.GitHub.Repos = map[string]Override{"owner/repo": {Similarity: &threshold}}
+		}, "github.repos.owner/repo.similarity_threshold"},
+		{"repo key is not owner/repo", func(c *Config) { c.GitHub.Repos = map[string]Override{"repo": {}} }, "not owner/repo"},
+		{"bigquery without table", func(c *Config) { c.GCP.BQTable = "" }, "gcp.bq_table"},
+		{"chunk overlap not below size", func(c *Config) { c.Embedding.Chunking.Overlap = 2000 }, "overlap"},
+		{"negative workers", func(c *Config) { c.Queue.Workers = -1 }, "queue.workers"},
+		{"negative max attempts", func(c *Config) { c.Queue.MaxAttempts = -1 }, "queue.max_attempts"},
+		{"negative backoff", func(c *Config) { c.Queue.InitialBackoff = -time.Second }, "queue.initial_backoff"},
+		{"initial backoff above max", func(c *Config) {
+			c.Queue.InitialBackoff = time.Minute
+			c.Queue.MaxBackoff = time.Second
+		}, "must not exceed queue.max_backoff"},
+		{"negative dedup ttl", func(c *Config) { c.Queue.DedupTTL = -time.Hour }, "queue.dedup_ttl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	c := validConfig()
+	c.Server.Port = -1
+	c.Queue.Workers = -1
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validate succeeded")
+	}
+	for _, want := range []string{"server.port", "queue.workers"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate error = %v, want it to mention %s", err, want)
+		}
+	}
+}
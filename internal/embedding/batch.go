@@ -0,0 +1,216 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/metrics"
+)
+
+// batchLimits bounds the size of one embedding request
+type batchLimits struct {
+	maxItems     int // Texts per request
+	maxTokens    int // Estimated input tokens per request
+	maxItemToken int // Tokens a single text counts for at most (longer texts are truncated by the API)
+	maxAttempts  int // Attempts per request on rate limits, server and network errors
+}
+
+var (
+	// Vertex AI text embedding models: 250 instances and 20,000 tokens per request,
+	// 2,048 tokens per instance (longer ones are truncated)
+	vertexLimits = batchLimits{maxItems: 250, maxTokens: 20000, maxItemToken: 2048, maxAttempts: 3}
+	// OpenAI: 2,048 inputs and 300,000 tokens per request, 8,192 tokens per input
+	openAILimits = batchLimits{maxItems: 2048, maxTokens: 300000, maxItemToken: 8192, maxAttempts: 3}
+)
+
+// retryBackoff is the delay before the first retry of a request; it doubles on each attempt
+const retryBackoff = time.Second
+
+// estimateTokens approximates the token count of s without the provider's tokenizer:
+// UTF-8 bytes / 3 is about one token per CJK character and a little over for English
+func estimateTokens(s string) int {
+	return len(s)/3 + 1
+}
+
+// pack splits inputs into consecutive [start, end) ranges that fit the limits
+func (l batchLimits) pack(inputs []Input) [][2]int {
+	var ranges [][2]int
+	start, tokens := 0, 0
+	for i, in := range inputs {
+		t := min(estimateTokens(in.Title+in.Text), l.maxItemToken)
+		if i > start && (i-start >= l.maxItems || tokens+t > l.maxTokens) {
+			ranges = append(ranges, [2]int{start, i})
+			start, tokens = i, 0
+		}
+		tokens += t
+	}
+	return append(ranges, [2]int{start, len(inputs)})
+}
+
+// APIError is an error response of an embedding API
+type APIError struct {
+	Provider   string
+	Status     string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s api error: %s: %s", e.Provider, e.Status, e.Body)
+}
+
+// checkStatus closes the response of a readiness check and turns an unexpected
+// status into an *APIError; statuses in accepted also count as reachable
+func checkStatus(provider string, resp *http.Response, accepted ...int) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	for _, code := range accepted {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &APIError{Provider: provider, Status: resp.Status, StatusCode: resp.StatusCode, Body: string(b)}
+}
+
+// errInvalidResponse marks responses that do not match the request or the configuration;
+// they are neither retried nor split
+var errInvalidResponse = errors.New("invalid embedding response")
+
+// retryable reports whether a failed request may succeed when sent again unchanged
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errInvalidResponse) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return true // Network errors
+}
+
+// BatchError reports the inputs of a batch that could not be embedded
+type BatchError struct {
+	Errors map[int]error // By input index
+	Total  int           // Number of inputs in the batch
+}
+
+func (e *BatchError) Error() string {
+	idx := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return fmt.Sprintf("embedding failed for %d of %d inputs (first: #%d: %v)", len(idx), e.Total, idx[0], e.Errors[idx[0]])
+}
+
+// Unwrap returns the per-input errors
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// batch is the state of one EmbedBatch call
+type batch struct {
+	*client
+	inputs      []Input
+	maxAttempts int
+	results     []*EmbeddingResult
+	failed      map[int]error
+	requests    int
+}
+
+// run embeds inputs[lo:hi] in one request. If the request keeps failing it is split
+// in halves, so that one bad text or an underestimated token count only fails the
+// texts that cannot be embedded.
+func (b *batch) run(ctx context.Context, lo, hi int) {
+	results, err := b.send(ctx, b.inputs[lo:hi])
+	if err == nil {
+		copy(b.results[lo:hi], results)
+		return
+	}
+	if hi-lo > 1 && ctx.Err() == nil && !errors.Is(err, errInvalidResponse) {
+		slog.WarnContext(ctx, "Embedding request failed, splitting it", "inputs", hi-lo, "error", err)
+		mid := (lo + hi) / 2
+		b.run(ctx, lo, mid)
+		b.run(ctx, mid, hi)
+		return
+	}
+	if b.failed == nil {
+		b.failed = make(map[int]error)
+	}
+	for i := lo; i < hi; i++ {
+		b.failed[i] = err
+	}
+}
+
+// send sends one request, retrying rate limits, server and network errors with backoff,
+// and checks and records the results
+func (b *batch) send(ctx context.Context, inputs []Input) ([]*EmbeddingResult, error) {
+	taskType := string(inputs[0].TaskType)
+	delay := retryBackoff
+	for attempt := 1; ; attempt++ {
+		b.requests++
+		start := time.Now()
+		results, err := b.provider.request(ctx, inputs)
+		if err == nil {
+			err = b.check(inputs, results)
+		}
+		metrics.EmbeddingDuration.WithLabelValues(taskType, metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+		if err == nil {
+			b.record(ctx, results)
+			return results, nil
+		}
+		if attempt >= b.maxAttempts || !retryable(err) {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Embedding request failed, retrying", "attempt", attempt, "max_attempts", b.maxAttempts, "retry_in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// check validates the number of results and their dimensions
+func (b *batch) check(inputs []Input, results []*EmbeddingResult) error {
+	if len(results) != len(inputs) {
+		return fmt.Errorf("%w: got %d embeddings for %d inputs", errInvalidResponse, len(results), len(inputs))
+	}
+	if b.dimensions <= 0 {
+		return nil
+	}
+	for _, r := range results {
+		if len(r.Embedding) != b.dimensions {
+			return fmt.Errorf("%w: embedding dimension mismatch: got %d, configured %d", errInvalidResponse, len(r.Embedding), b.dimensions)
+		}
+	}
+	return nil
+}
+
+// record updates the per-text metrics
+func (b *batch) record(ctx context.Context, results []*EmbeddingResult) {
+	for _, r := range results {
+		if r.TokenCount > 0 { // Not every provider reports tokens per text
+			metrics.EmbeddingTokens.Observe(float64(r.TokenCount))
+		}
+		if r.Truncated {
+			slog.WarnContext(ctx, "Input text was truncated to the model's token limit", "token_count", r.TokenCount)
+			metrics.EmbeddingTruncations.Inc()
+		}
+	}
+	slog.DebugContext(ctx, "Created embeddings", "provider", b.name, "count", len(results), "dimensions", len(results[0].Embedding))
+}
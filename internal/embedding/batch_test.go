@@ -0,0 +1,117 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeProvider embeds a text as its length and records the inputs of each request.
+// Requests containing failText, or every request if fail is set, are rejected.
+type fakeProvider struct {
+	ignoreHints bool // Normalize inputs like a provider without task types
+	fail        bool
+	failText    string
+	requests    [][]Input
+}
+
+func (f *fakeProvider) normalize(in Input) Input {
+	if f.ignoreHints {
+		return (&OpenAI{}).normalize(in)
+	}
+	return in
+}
+
+func (f *fakeProvider) check(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeProvider) request(ctx context.Context, inputs []Input) ([]*EmbeddingResult, error) {
+	f.requests = append(f.requests, inputs)
+	if f.fail {
+		return nil, errors.New("unavailable")
+	}
+	results := make([]*EmbeddingResult, len(inputs))
+	for i, in := range inputs {
+		if f.failText != "" && in.Text == f.failText {
+			return nil, &APIError{Provider: "fake", Status: "400 Bad Request", StatusCode: http.StatusBadRequest}
+		}
+		results[i] = &EmbeddingResult{Embedding: []float64{float64(len(in.Title + in.Text))}}
+	}
+	return results, nil
+}
+
+func TestPack(t *testing.T) {
+	short := Input{Text: "ab"}                     // 1 token
+	long := Input{Text: strings.Repeat("x", 30)}   // 11 tokens
+	huge := Input{Text: strings.Repeat("x", 3000)} // capped at maxItemToken
+	tests := []struct {
+		name   string
+		limits batchLimits
+		inputs []Input
+		want   [][2]int
+	}{
+		{"fits one request", batchLimits{maxItems: 10, maxTokens: 100, maxItemToken: 50}, []Input{short, short, short}, [][2]int{{0, 3}}},
+		{"item limit", batchLimits{maxItems: 2, maxTokens: 100, maxItemToken: 50}, []Input{short, short, short}, [][2]int{{0, 2}, {2, 3}}},
+		{"token limit", batchLimits{maxItems: 10, maxTokens: 20, maxItemToken: 50}, []Input{long, long, short}, [][2]int{{0, 1}, {1, 3}}},
+		{"long texts count as truncated", batchLimits{maxItems: 10, maxTokens: 20, maxItemToken: 10}, []Input{huge, huge}, [][2]int{{0, 2}}},
+		{"oversized text gets its own request", batchLimits{maxItems: 10, maxTokens: 5, maxItemToken: 50}, []Input{short, long, short}, [][2]int{{0, 1}, {1, 2}, {2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.pack(tt.inputs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pack = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbedBatchSplitsFailingRequests(t *testing.T) {
+	p := &fakeProvider{failText: "bad"}
+	c := &client{provider: p, limits: vertexLimits}
+	c.limits.maxAttempts = 1
+	inputs := []Input{{Text: "a"}, {Text: "bb"}, {Text: "bad"}, {Text: "dddd"}}
+
+	results, err := c.EmbedBatch(context.Background(), inputs)
+	var be *BatchError
+	if !errors.As(err, &be) {
+		t.Fatalf("error = %v, want a *BatchError", err)
+	}
+	if len(be.Errors) != 1 || be.Errors[2] == nil || be.Total != len(inputs) {
+		t.Errorf("BatchError = %+v, want only input 2 failed", be)
+	}
+	for i, r := range results {
+		if (r == nil) != (i == 2) {
+			t.Errorf("result %d = %v, want a result for every input but the failing one", i, r)
+		}
+	}
+	// One request for all inputs, then halves, then quarters of the failing half
+	if len(p.requests) != 5 {
+		t.Errorf("sent %d requests, want 5", len(p.requests))
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &APIError{StatusCode: http.StatusTooManyRequests}, true},
+		{"server error", &APIError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"bad request", &APIError{StatusCode: http.StatusBadRequest}, false},
+		{"network error", errors.New("connection reset"), true},
+		{"cancelled", context.Canceled, false},
+		{"invalid response", errInvalidResponse, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
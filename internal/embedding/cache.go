@@ -0,0 +1,209 @@
+package embedding
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/metrics"
+)
+
+// cache is an LRU of embedding results keyed by model, task type and a hash of the input.
+// With a path it is loaded on start and saved every flush interval when changed and on close.
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+	path    string
+	dirty   bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// cacheEntry is one cached result; it is also the on-disk record
+type cacheEntry struct {
+	Key    string
+	Result EmbeddingResult
+}
+
+// newCache creates a cache of up to size entries, loading path if it exists. size <= 0
+// returns nil, which caches nothing.
+func newCache(size int, path string, flushInterval time.Duration) (*cache, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	c := &cache{size: size, entries: make(map[string]*list.Element), order: list.New(), path: path}
+	if path == "" {
+		return c, nil
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.stop, c.done = make(chan struct{}), make(chan struct{})
+	go c.flushLoop(flushInterval)
+	return c, nil
+}
+
+// cacheKey identifies an input's embedding: the model, output dimensions and task type
+// are part of the key so that changing them does not return stale vectors
+func (c *client) cacheKey(in Input) string {
+	h := sha256.New()
+	for _, s := range []string{c.name, c.model, strconv.Itoa(c.dimensions), string(in.TaskType), in.Title, in.Text} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the cached result for key and marks it as recently used
+func (c *cache) get(key string) (*EmbeddingResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		metrics.EmbeddingCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	metrics.EmbeddingCacheRequests.WithLabelValues("hit").Inc()
+	c.order.MoveToFront(e)
+	r := e.Value.(*cacheEntry).Result
+	return &r, true
+}
+
+// put stores a result, evicting the least recently used entry when the cache is full
+func (c *cache) put(key string, r *EmbeddingResult) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(&cacheEntry{Key: key, Result: *r})
+	c.dirty = true
+}
+
+// add inserts or refreshes an entry; the caller must hold c.mu
+func (c *cache) add(entry *cacheEntry) {
+	if e, ok := c.entries[entry.Key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[entry.Key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).Key)
+	}
+	metrics.EmbeddingCacheEntries.Set(float64(c.order.Len()))
+}
+
+// close stops the flush loop and saves the cache
+func (c *cache) close() error {
+	if c == nil || c.path == "" {
+		return nil
+	}
+	close(c.stop)
+	<-c.done
+	return c.flush()
+}
+
+// flushLoop saves the cache every interval while it has changes
+func (c *cache) flushLoop(interval time.Duration) {
+	defer close(c.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := c.flush(); err != nil {
+				slog.Error("Failed to save embedding cache", "path", c.path, "error", err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// flush saves the cache if it changed since the last save
+func (c *cache) flush() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	entries := make([]*cacheEntry, 0, c.order.Len())
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		entries = append(entries, e.Value.(*cacheEntry))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	if err := c.save(entries); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+	slog.Debug("Saved embedding cache", "path", c.path, "entries", len(entries))
+	return nil
+}
+
+// load reads the entries saved at c.path; a missing file means an empty cache
+func (c *cache) load() error {
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open embedding cache: %w", err)
+	}
+	defer f.Close()
+
+	var entries []*cacheEntry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return fmt.Errorf("decode embedding cache: %w", err)
+	}
+	// Saved most recently used first; add the oldest first so that they are evicted first
+	for i := len(entries) - 1; i >= 0; i-- {
+		c.add(entries[i])
+	}
+	slog.Info("Embedding cache loaded", "path", c.path, "entries", c.order.Len())
+	return nil
+}
+
+// save atomically rewrites the cache file with entries
+func (c *cache) save(entries []*cacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("create embedding cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create embedding cache temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encode embedding cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close embedding cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("persist embedding cache: %w", err)
+	}
+	return nil
+}
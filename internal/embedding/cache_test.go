@@ -0,0 +1,77 @@
+package embedding
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newCache(2, "", 0)
+	c.put("a", &EmbeddingResult{Embedding: []float64{1}})
+	c.put("b", &EmbeddingResult{Embedding: []float64{2}})
+	c.get("a") // a is now more recently used than b
+	c.put("c", &EmbeddingResult{Embedding: []float64{3}})
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(key); ok != want {
+			t.Errorf("get(%q) found = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "embeddings.gob")
+	c, err := newCache(10, path, time.Hour)
+	if err != nil {
+		t.Fatalf("newCache: %v", err)
+	}
+	c.put("a", &EmbeddingResult{Embedding: []float64{1, 2}, TokenCount: 3})
+	if err := c.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reloaded, err := newCache(10, path, time.Hour)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	defer reloaded.close()
+	r, ok := reloaded.get("a")
+	if !ok || len(r.Embedding) != 2 || r.TokenCount != 3 {
+		t.Errorf("reloaded get = %+v, %v, want the saved result", r, ok)
+	}
+}
+
+func TestEmbedBatchUsesCache(t *testing.T) {
+	p := &fakeProvider{}
+	c := &client{provider: p, name: "fake", limits: vertexLimits}
+	c.cache, _ = newCache(10, "", 0)
+	query := Input{Text: "body", TaskType: TaskTypeRetrievalQuery}
+	doc := Input{Text: "body", TaskType: TaskTypeRetrievalDocument}
+
+	for call, want := range []int{1, 1} {
+		before := len(p.requests)
+		// The query is cached by the first call; the document differs only in its task type
+		inputs := []Input{query}
+		if call == 1 {
+			inputs = append(inputs, doc)
+		}
+		results, err := c.EmbedBatch(context.Background(), inputs)
+		if err != nil {
+			t.Fatalf("call %d: %v", call, err)
+		}
+		for i, r := range results {
+			if r == nil {
+				t.Fatalf("call %d: result %d is nil", call, i)
+			}
+		}
+		sent := 0
+		for _, req := range p.requests[before:] {
+			sent += len(req)
+		}
+		if sent != want {
+			t.Errorf("call %d sent %d inputs, want %d", call, sent, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package embedding
+
+import "unicode"
+
+// Chunk splits text into pieces of at most size characters that overlap by overlap
+// characters, so that long texts are embedded in full instead of being truncated at the
+// model's input limit. Pieces end at a line break, or else a space, in their second half
+// where possible. A text of up to size characters, or size <= 0, is returned as one piece.
+func Chunk(text string, size, overlap int) []string {
+	r := []rune(text)
+	if size <= 0 || len(r) <= size {
+		return []string{text}
+	}
+	overlap = min(max(overlap, 0), size-1)
+
+	var chunks []string
+	for start := 0; ; {
+		end := start + size
+		if end >= len(r) {
+			return append(chunks, string(r[start:]))
+		}
+		end = breakPoint(r, start+size/2, end)
+		chunks = append(chunks, string(r[start:end]))
+		start = max(end-overlap, start+1)
+	}
+}
+
+// breakPoint returns the position in (lo, hi] just after the last line break,
+// or else the last space, or hi if there is neither
+func breakPoint(r []rune, lo, hi int) int {
+	for i := hi; i > lo; i-- {
+		if r[i-1] == '\n' {
+			return i
+		}
+	}
+	for i := hi; i > lo; i-- {
+		if unicode.IsSpace(r[i-1]) {
+			return i
+		}
+	}
+	return hi
+}
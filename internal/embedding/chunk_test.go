@@ -0,0 +1,33 @@
+package embedding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		size    int
+		overlap int
+		want    []string
+	}{
+		{"short text is one chunk", "hello", 10, 2, []string{"hello"}},
+		{"size 0 disables chunking", strings.Repeat("a", 50), 0, 0, []string{strings.Repeat("a", 50)}},
+		{"empty text", "", 10, 2, []string{""}},
+		{"hard split with overlap", "abcdefghij", 4, 1, []string{"abcd", "defg", "ghij"}},
+		{"breaks after a line break", "aaa\nbbb\nccc", 6, 0, []string{"aaa\n", "bbb\n", "ccc"}},
+		{"breaks after a space", "one two three", 9, 0, []string{"one two ", "three"}},
+		{"counts runes, not bytes", "あいうえおかきく", 4, 0, []string{"あいうえ", "おかきく"}},
+		{"overlap is capped below size", "abcdef", 3, 5, []string{"abc", "bcd", "cde", "def"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Chunk(tt.text, tt.size, tt.overlap); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Chunk(%q, %d, %d) = %q, want %q", tt.text, tt.size, tt.overlap, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,45 +1,19 @@
-// Package embedding provides functionality to create text embeddings using Vertex AI
+// Package embedding provides functionality to create text embeddings with Vertex AI
+// or any OpenAI-compatible embeddings API
 package embedding
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"os"
+	"log/slog"
 	"strings"
 
 	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// Response represents the structure of Vertex AI embedding API response
-type embedResp struct {
-	Predictions []struct {
-		Embeddings struct {
-			Values     []float64 `json:"values"`
-			Statistics struct {
-				TokenCount int  `json:"token_count"`
-				Truncated  bool `json:"truncated"`
-			} `json:"statistics"`
-		} `json:"embeddings"`
-	} `json:"predictions"`
-}
-
-// Request structure for Vertex AI embedding API
-type embedReq struct {
-	Instances []instanceReq `json:"instances"`
-}
-
-// instanceReq represents a single embedding request instance
-type instanceReq struct {
-	TaskType string `json:"task_type,omitempty"`
-	Title    string `json:"title,omitempty"`
-	Content  string `json:"content"`
-}
-
 // TaskType defines the different types of embedding tasks
 type TaskType string
 
@@ -55,104 +29,190 @@ const (
 	TaskTypeCodeRetrievalQuery TaskType = "CODE_RETRIEVAL_QUERY"
 )
 
-// buildVertexAIEndpoint constructs the Vertex AI API endpoint URL
-func buildVertexAIEndpoint(cfg *config.Config) string {
-	region := strings.ToLower(cfg.GCP.Region)
-	return fmt.Sprintf(
-		"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
-		region,
-		cfg.GCP.ProjectID,
-		region,
-		cfg.GCP.EmbeddingModel,
-	)
-}
-
 // EmbeddingResult contains the embedding vector and metadata
 type EmbeddingResult struct {
 	Embedding  []float64
-	TokenCount int
+	TokenCount int // Input tokens; 0 when the provider does not report them per text
 	Truncated  bool
 }
 
-// CreateEmbedding creates a vector embedding for the given text using Vertex AI
-// Defaults to RETRIEVAL_DOCUMENT task type
-func CreateEmbedding(ctx context.Context, cfg *config.Config, text string) ([]float64, error) {
-	result, err := CreateEmbeddingWithOptions(ctx, cfg, text, string(TaskTypeRetrievalDocument), "")
-	if err != nil {
-		return nil, err
-	}
-	return result.Embedding, nil
+// Input is one text to embed. TaskType and Title (the document's title, optional)
+// are hints that providers without task-specific models ignore.
+type Input struct {
+	Text     string
+	TaskType TaskType
+	Title    string
+}
+
+// Embedder creates text embeddings with the configured provider
+type Embedder interface {
+	// Embed returns the embedding of a single text
+	Embed(ctx context.Context, text string, taskType TaskType, title string) (*EmbeddingResult, error)
+	// EmbedBatch embeds many texts with as few requests as the provider's limits allow.
+	// Results are in input order. If some inputs fail, their results are nil and the
+	// error is a *BatchError.
+	EmbedBatch(ctx context.Context, inputs []Input) ([]*EmbeddingResult, error)
+	// Check verifies that the provider is reachable and accepts our credentials
+	// without creating an embedding, so it is not billed
+	Check(ctx context.Context) error
+	// Ping checks the embedding endpoint itself by embedding a short text, bypassing
+	// the cache; unlike Check it is billed
+	Ping(ctx context.Context) error
+	// Close saves the embedding cache; it is called once during shutdown
+	Close() error
+}
+
+// provider sends one embedding request for inputs and returns the results in input order
+type provider interface {
+	request(ctx context.Context, inputs []Input) ([]*EmbeddingResult, error)
+	// normalize returns in as the provider sends it, dropping the hints it ignores
+	normalize(in Input) Input
+	// check calls an unbilled endpoint of the provider with our credentials
+	check(ctx context.Context) error
 }
 
-// CreateEmbeddingWithOptions creates a vector embedding with specific task type and title
-func CreateEmbeddingWithOptions(ctx context.Context, cfg *config.Config, text, taskType, title string) (*EmbeddingResult, error) {
-	log.Printf("DEBUG: Creating embedding for text (length: %d characters)", len(text))
-	
-	endpoint := buildVertexAIEndpoint(cfg)
-	log.Printf("DEBUG: Using Vertex AI endpoint: %s", endpoint)
-
-	// Create a properly structured request according to Vertex AI documentation
-	request := embedReq{
-		Instances: []instanceReq{
-			{
-				TaskType: taskType,
-				Title:    title,
-				Content:  text,
-			},
-		},
+// New creates the embedder selected by cfg.Embedding.Provider (vertexai by default).
+// The returned embedder records metrics and spans and checks the vector dimensions.
+func New(cfg *config.Config) (Embedder, error) {
+	c := &client{dimensions: cfg.GCP.VectorSearch.Dimensions}
+	switch strings.ToLower(cfg.Embedding.Provider) {
+	case "", "vertexai":
+		c.name, c.model, c.provider = "vertexai", cfg.GCP.EmbeddingModel, NewVertexAI(cfg)
+		c.limits = vertexLimits
+	case "openai":
+		c.name, c.model, c.provider = "openai", cfg.Embedding.OpenAI.Model, NewOpenAI(cfg)
+		c.limits = openAILimits
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", cfg.Embedding.Provider)
 	}
-	
-	body, err := json.Marshal(request)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal request: %v", err)
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	bc := cfg.Embedding.Batch
+	if bc.MaxItems > 0 {
+		c.limits.maxItems = bc.MaxItems
 	}
-	log.Printf("DEBUG: Request payload size: %d bytes", len(body))
-
-	req, _ := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	if key := os.Getenv("VERTEX_API_KEY"); key != "" {
-		log.Printf("DEBUG: Using Vertex API key for authentication (length: %d)", len(key))
-		req.Header.Set("Authorization", "Bearer "+key)
-	} else {
-		log.Printf("DEBUG: No Vertex API key found, using Application Default Credentials")
+	if bc.MaxTokens > 0 {
+		c.limits.maxTokens = bc.MaxTokens
 	}
-
-	log.Printf("DEBUG: Sending request to Vertex AI embedding endpoint")
-	resp, err := http.DefaultClient.Do(req)
+	if bc.MaxAttempts > 0 {
+		c.limits.maxAttempts = bc.MaxAttempts
+	}
+	cc := cfg.Embedding.Cache
+	cache, err := newCache(cc.Size, cc.Path, cc.FlushInterval)
 	if err != nil {
-		log.Printf("ERROR: Vertex AI request failed: %v", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
+	c.cache = cache
+	slog.Info("Embedding provider configured", "provider", c.name, "model", c.model,
+		"batch_max_items", c.limits.maxItems, "batch_max_tokens", c.limits.maxTokens, "cache_size", cc.Size)
+	return c, nil
+}
 
-	if resp.StatusCode != 200 {
-		b, _ := io.ReadAll(resp.Body)
-		log.Printf("ERROR: Vertex API returned non-200 response: %s, body: %s", resp.Status, string(b))
-		return nil, fmt.Errorf("vertex api error: %s: %s", resp.Status, string(b))
-	}
-	log.Printf("DEBUG: Vertex AI response received with status: %s", resp.Status)
+// client implements Embedder on top of a provider: it packs batches, retries and splits
+// failed requests, and records tracing and metrics
+type client struct {
+	provider   provider
+	name       string
+	model      string
+	limits     batchLimits
+	dimensions int    // Expected vector size; 0 accepts any
+	cache      *cache // nil when embedding.cache.size is 0
+}
 
-	var out embedResp
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		log.Printf("ERROR: Failed to decode Vertex AI response: %v", err)
+// Embed sends a single request without retries; callers such as the job queue retry the step
+func (c *client) Embed(ctx context.Context, text string, taskType TaskType, title string) (*EmbeddingResult, error) {
+	results, err := c.embed(ctx, []Input{{Text: text, TaskType: taskType, Title: title}}, 1, true)
+	var be *BatchError
+	if errors.As(err, &be) {
+		return nil, be.Errors[0]
+	}
+	if err != nil {
 		return nil, err
 	}
+	return results[0], nil
+}
 
-	if len(out.Predictions) == 0 {
-		log.Printf("ERROR: Vertex API returned empty predictions array")
-		return nil, fmt.Errorf("vertex api: empty predictions")
-	}
+func (c *client) EmbedBatch(ctx context.Context, inputs []Input) ([]*EmbeddingResult, error) {
+	return c.embed(ctx, inputs, c.limits.maxAttempts, true)
+}
 
-	result := &EmbeddingResult{
-		Embedding:  out.Predictions[0].Embeddings.Values,
-		TokenCount: out.Predictions[0].Embeddings.Statistics.TokenCount,
-		Truncated:  out.Predictions[0].Embeddings.Statistics.Truncated,
-	}
+func (c *client) Check(ctx context.Context) error {
+	return c.provider.check(ctx)
+}
 
-	embeddingSize := len(result.Embedding)
-	log.Printf("DEBUG: Successfully created embedding with %d dimensions (tokens: %d, truncated: %v)", 
-		embeddingSize, result.TokenCount, result.Truncated)
-	
-	return result, nil
+func (c *client) Ping(ctx context.Context) error {
+	_, err := c.embed(ctx, []Input{{Text: "ping", TaskType: TaskTypeRetrievalQuery}}, 1, false)
+	return err
+}
+
+func (c *client) Close() error {
+	return c.cache.close()
+}
+
+// embed answers inputs from the cache where possible, packs the rest into requests and makes
+// up to maxAttempts attempts per request. Inputs the provider would send identically, such as
+// ones differing only in an ignored task type, are embedded once and share the result.
+func (c *client) embed(ctx context.Context, inputs []Input, maxAttempts int, useCache bool) (_ []*EmbeddingResult, err error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+	ctx, span := tracing.Start(ctx, "embedding.create",
+		attribute.String("embedding.provider", c.name),
+		attribute.String("embedding.model", c.model),
+		attribute.String("embedding.task_type", string(inputs[0].TaskType)),
+		attribute.Int("embedding.inputs", len(inputs)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	results := make([]*EmbeddingResult, len(inputs))
+	first := make(map[Input]int, len(inputs)) // Index of the first occurrence of each input
+	dups := make(map[int]int)                 // Index of a repeated input -> its first occurrence
+	b := &batch{client: c, maxAttempts: maxAttempts}
+	var keys []string
+	var missing []int // Indexes of the inputs to send
+	for i, in := range inputs {
+		in = c.provider.normalize(in)
+		if f, ok := first[in]; ok {
+			dups[i] = f
+			continue
+		}
+		first[in] = i
+		if useCache && c.cache != nil {
+			key := c.cacheKey(in)
+			if r, ok := c.cache.get(key); ok {
+				results[i] = r
+				continue
+			}
+			keys = append(keys, key)
+		}
+		missing = append(missing, i)
+		b.inputs = append(b.inputs, in)
+	}
+	span.SetAttributes(attribute.Int("embedding.cache_hits", len(first)-len(missing)))
+
+	failed := make(map[int]error)
+	if len(missing) > 0 {
+		b.results = make([]*EmbeddingResult, len(missing))
+		for _, r := range c.limits.pack(b.inputs) {
+			b.run(ctx, r[0], r[1])
+		}
+		span.SetAttributes(attribute.Int("embedding.requests", b.requests))
+		for j, i := range missing {
+			results[i] = b.results[j]
+			if results[i] != nil && keys != nil {
+				c.cache.put(keys[j], results[i])
+			}
+		}
+		for j, err := range b.failed {
+			failed[missing[j]] = err
+		}
+	}
+	for i, f := range dups {
+		results[i] = results[f]
+		if err, ok := failed[f]; ok {
+			failed[i] = err
+		}
+	}
+	if len(failed) > 0 {
+		return results, &BatchError{Errors: failed, Total: len(inputs)}
+	}
+	return results, nil
 }
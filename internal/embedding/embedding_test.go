@@ -0,0 +1,64 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEmbedBatch(t *testing.T) {
+	query := Input{Text: "title\nbody", TaskType: TaskTypeRetrievalQuery}
+	doc := Input{Text: "body", Title: "title", TaskType: TaskTypeRetrievalDocument}
+	tests := []struct {
+		name        string
+		ignoreHints bool
+		inputs      []Input
+		wantSent    int
+	}{
+		{"task types differ", false, []Input{query, doc}, 2},
+		{"provider ignores task types", true, []Input{query, doc}, 1},
+		{"exact duplicates", false, []Input{query, query}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeProvider{ignoreHints: tt.ignoreHints}
+			c := &client{provider: p, limits: vertexLimits}
+			results, err := c.EmbedBatch(context.Background(), tt.inputs)
+			if err != nil {
+				t.Fatalf("EmbedBatch: %v", err)
+			}
+			if len(results) != len(tt.inputs) {
+				t.Fatalf("%d results for %d inputs", len(results), len(tt.inputs))
+			}
+			for i, r := range results {
+				if r == nil {
+					t.Fatalf("result %d is nil", i)
+				}
+			}
+			sent := 0
+			for _, req := range p.requests {
+				sent += len(req)
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent %d inputs, want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestEmbedBatchErrorCoversDuplicates(t *testing.T) {
+	p := &fakeProvider{ignoreHints: true, fail: true}
+	c := &client{provider: p, limits: vertexLimits}
+	c.limits.maxAttempts = 1
+	_, err := c.EmbedBatch(context.Background(), []Input{
+		{Text: "same", TaskType: TaskTypeRetrievalQuery},
+		{Text: "same", TaskType: TaskTypeRetrievalDocument},
+	})
+	var be *BatchError
+	if !errors.As(err, &be) {
+		t.Fatalf("error = %v, want a *BatchError", err)
+	}
+	if len(be.Errors) != 2 || be.Errors[0] == nil || be.Errors[1] == nil {
+		t.Errorf("BatchError.Errors = %v, want both inputs failed", be.Errors)
+	}
+}
@@ -0,0 +1,173 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
+)
+
+// openAIReq is the request body of POST /embeddings
+type openAIReq struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+// openAIResp is the response body of POST /embeddings
+type openAIResp struct {
+	Data []struct {
+		Embedding []float64 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+// OpenAI embeds texts with an OpenAI-compatible /embeddings endpoint: OpenAI, Azure OpenAI,
+// Ollama, llama.cpp server, vLLM and others. Task types and titles are not supported by the
+// API and are ignored, and token counts are only known for single-text requests.
+type OpenAI struct {
+	endpoint     string
+	model        string
+	apiKey       string // OPENAI_API_KEY; local servers usually need none
+	apiKeyHeader string // Header carrying apiKey; Authorization sends it as a bearer token
+	dimensions   int    // Sent as the dimensions parameter when set
+	client       *http.Client
+}
+
+// NewOpenAI creates an embedder for the OpenAI-compatible API at embedding.openai.base_url
+func NewOpenAI(cfg *config.Config) *OpenAI {
+	oc := cfg.Embedding.OpenAI
+	e := &OpenAI{
+		endpoint:     openAIEndpoint(oc.BaseURL),
+		model:        oc.Model,
+		apiKey:       os.Getenv("OPENAI_API_KEY"),
+		apiKeyHeader: oc.APIKeyHeader,
+		client:       &http.Client{Transport: tracing.Transport("openai", nil)},
+	}
+	if e.apiKeyHeader == "" {
+		e.apiKeyHeader = "Authorization"
+	}
+	if oc.SendDimensions {
+		e.dimensions = cfg.GCP.VectorSearch.Dimensions
+	}
+	return e
+}
+
+// openAIModelsEndpoint is the models listing next to the embeddings endpoint
+func openAIModelsEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return strings.TrimSuffix(endpoint, "/embeddings") + "/models"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/embeddings") + "/models"
+	return u.String()
+}
+
+// openAIEndpoint appends /embeddings to the base URL unless it already ends with it,
+// keeping any query string (e.g. Azure's api-version)
+func openAIEndpoint(base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return strings.TrimSuffix(base, "/") + "/embeddings"
+	}
+	if !strings.HasSuffix(u.Path, "/embeddings") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/embeddings"
+	}
+	return u.String()
+}
+
+// normalize drops the task type and prepends the title, which the API has no field for
+func (o *OpenAI) normalize(in Input) Input {
+	if in.Title != "" {
+		return Input{Text: in.Title + "\n" + in.Text}
+	}
+	return Input{Text: in.Text}
+}
+
+// request calls the /embeddings endpoint with all inputs
+func (o *OpenAI) request(ctx context.Context, inputs []Input) ([]*EmbeddingResult, error) {
+	texts := make([]string, len(inputs))
+	for i, in := range inputs {
+		texts[i] = o.normalize(in).Text
+	}
+	body, err := json.Marshal(openAIReq{Model: o.model, Input: texts, Dimensions: o.dimensions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", o.endpoint, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	o.authorize(req)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		slog.ErrorContext(ctx, "Embedding request failed", "endpoint", o.endpoint, "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		slog.ErrorContext(ctx, "Embedding API returned an error", "endpoint", o.endpoint, "status", resp.Status, "response", string(b))
+		return nil, &APIError{Provider: "embeddings", Status: resp.Status, StatusCode: resp.StatusCode, Body: string(b)}
+	}
+
+	var out openAIResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode embeddings response", "error", err)
+		return nil, err
+	}
+	if len(out.Data) != len(inputs) {
+		return nil, fmt.Errorf("embeddings api: got %d embeddings for %d inputs", len(out.Data), len(inputs))
+	}
+
+	// Data carries the input index and is not guaranteed to be in order
+	results := make([]*EmbeddingResult, len(inputs))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(inputs) || results[d.Index] != nil {
+			return nil, fmt.Errorf("embeddings api: invalid index %d", d.Index)
+		}
+		results[d.Index] = &EmbeddingResult{Embedding: d.Embedding}
+	}
+	// Usage is only reported for the whole request
+	if len(inputs) == 1 {
+		results[0].TokenCount = out.Usage.PromptTokens
+	}
+	return results, nil
+}
+
+// check lists the models (GET /models), which is free on OpenAI and served by most
+// compatible servers. Servers without the endpoint still prove they are reachable.
+func (o *OpenAI) check(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, "GET", openAIModelsEndpoint(o.endpoint), nil)
+	o.authorize(req)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkStatus("embeddings", resp, http.StatusNotFound, http.StatusMethodNotAllowed)
+}
+
+// authorize adds the API key to req
+func (o *OpenAI) authorize(req *http.Request) {
+	if o.apiKey == "" {
+		return
+	}
+	if strings.EqualFold(o.apiKeyHeader, "Authorization") {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	} else {
+		req.Header.Set(o.apiKeyHeader, o.apiKey)
+	}
+}
@@ -0,0 +1,44 @@
+package embedding
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+)
+
+func TestOpenAICheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"models listed", http.StatusOK, false},
+		{"no models endpoint", http.StatusNotFound, false},
+		{"bad key", http.StatusUnauthorized, true},
+		{"server error", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, auth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, auth = r.Method+" "+r.URL.Path, r.Header.Get("Authorization")
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			t.Setenv("OPENAI_API_KEY", "sk-test")
+			cfg := &config.Config{}
+			cfg.Embedding.OpenAI.BaseURL = srv.URL + "/v1"
+
+			err := NewOpenAI(cfg).check(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check error = %v, want error %v", err, tt.wantErr)
+			}
+			if path != "GET /v1/models" || auth != "Bearer sk-test" {
+				t.Errorf("request = %q with Authorization %q, want GET /v1/models with the API key", path, auth)
+			}
+		})
+	}
+}
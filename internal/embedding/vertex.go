@@ -0,0 +1,169 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
+)
+
+// Response represents the structure of Vertex AI embedding API response
+type embedResp struct {
+	Predictions []struct {
+		Embeddings struct {
+			Values     []float64 `json:"values"`
+			Statistics struct {
+				TokenCount int  `json:"token_count"`
+				Truncated  bool `json:"truncated"`
+			} `json:"statistics"`
+		} `json:"embeddings"`
+	} `json:"predictions"`
+}
+
+// Request structure for Vertex AI embedding API
+type embedReq struct {
+	Instances  []instanceReq  `json:"instances"`
+	Parameters *parametersReq `json:"parameters,omitempty"`
+}
+
+// parametersReq holds request-wide options for the embedding API
+type parametersReq struct {
+	OutputDimensionality int `json:"outputDimensionality,omitempty"`
+}
+
+// instanceReq represents a single embedding request instance
+type instanceReq struct {
+	TaskType string `json:"task_type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Content  string `json:"content"`
+}
+
+// VertexAI embeds texts with a Vertex AI text embedding model (gcp.embedding_model in gcp.region)
+type VertexAI struct {
+	endpoint   string
+	modelURL   string // Publisher model resource, read by check
+	apiKey     string // VERTEX_API_KEY; empty relies on the environment's credentials
+	dimensions int    // Sent as outputDimensionality when gcp.send_dimensions is set
+	client     *http.Client
+}
+
+// NewVertexAI creates a Vertex AI embedder
+func NewVertexAI(cfg *config.Config) *VertexAI {
+	v := &VertexAI{
+		endpoint: buildVertexAIEndpoint(cfg),
+		modelURL: buildVertexAIModelURL(cfg),
+		apiKey:   os.Getenv("VERTEX_API_KEY"),
+		client:   &http.Client{Transport: tracing.Transport("vertexai", nil)},
+	}
+	if cfg.GCP.SendDimensions {
+		v.dimensions = cfg.GCP.VectorSearch.Dimensions
+	}
+	return v
+}
+
+// buildVertexAIEndpoint constructs the Vertex AI API endpoint URL
+func buildVertexAIEndpoint(cfg *config.Config) string {
+	region := strings.ToLower(cfg.GCP.Region)
+	return fmt.Sprintf(
+		"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
+		region,
+		cfg.GCP.ProjectID,
+		region,
+		cfg.GCP.EmbeddingModel,
+	)
+}
+
+// buildVertexAIModelURL constructs the URL of the publisher model resource
+func buildVertexAIModelURL(cfg *config.Config) string {
+	region := strings.ToLower(cfg.GCP.Region)
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/publishers/google/models/%s", region, cfg.GCP.EmbeddingModel)
+}
+
+// check reads the model's metadata, which verifies the region, model and credentials
+// without running a prediction
+func (v *VertexAI) check(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, "GET", v.modelURL, nil)
+	if v.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkStatus("vertex", resp)
+}
+
+// normalize returns in unchanged: Vertex AI uses both the task type and the title
+func (v *VertexAI) normalize(in Input) Input {
+	return in
+}
+
+// request calls the Vertex AI :predict endpoint with one instance per input
+func (v *VertexAI) request(ctx context.Context, inputs []Input) ([]*EmbeddingResult, error) {
+	// Create a properly structured request according to Vertex AI documentation
+	request := embedReq{Instances: make([]instanceReq, len(inputs))}
+	for i, in := range inputs {
+		request.Instances[i] = instanceReq{
+			TaskType: string(in.TaskType),
+			Title:    in.Title,
+			Content:  in.Text,
+		}
+	}
+	if v.dimensions > 0 {
+		request.Parameters = &parametersReq{OutputDimensionality: v.dimensions}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", v.endpoint, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if v.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		slog.ErrorContext(ctx, "Vertex AI request failed", "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		slog.ErrorContext(ctx, "Vertex AI returned an error", "status", resp.Status, "response", string(b))
+		return nil, &APIError{Provider: "vertex", Status: resp.Status, StatusCode: resp.StatusCode, Body: string(b)}
+	}
+
+	var out embedResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode Vertex AI response", "error", err)
+		return nil, err
+	}
+
+	if len(out.Predictions) == 0 {
+		slog.ErrorContext(ctx, "Vertex AI returned no predictions")
+		return nil, fmt.Errorf("vertex api: empty predictions")
+	}
+
+	// Predictions are in instance order
+	results := make([]*EmbeddingResult, len(out.Predictions))
+	for i, p := range out.Predictions {
+		results[i] = &EmbeddingResult{
+			Embedding:  p.Embeddings.Values,
+			TokenCount: p.Embeddings.Statistics.TokenCount,
+			Truncated:  p.Embeddings.Statistics.Truncated,
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,167 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
+	"github.com/google/go-github/v62/github"
+	"golang.org/x/oauth2"
+)
+
+// tokenRefreshMargin is how long before expiry an installation token is replaced
+const tokenRefreshMargin = 5 * time.Minute
+
+// installationKey is the context key carrying the GitHub App installation ID of a webhook
+type installationKey struct{}
+
+// WithInstallation returns a context whose API calls are authenticated as the given
+// GitHub App installation (the payload's installation.id). Zero leaves ctx unchanged.
+func WithInstallation(ctx context.Context, installationID int64) context.Context {
+	if installationID == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, installationKey{}, installationID)
+}
+
+// installationFrom returns the installation ID stored by WithInstallation, or 0
+func installationFrom(ctx context.Context) int64 {
+	id, _ := ctx.Value(installationKey{}).(int64)
+	return id
+}
+
+// app authenticates as a GitHub App and hands out clients for its installations.
+// Installation tokens are minted on demand, cached per installation and refreshed before they expire.
+type app struct {
+	id      int64
+	key     *rsa.PrivateKey
+	api     *github.Client // Authenticated with the app JWT, used to mint installation tokens
+	baseCtx context.Context
+
+	mu      sync.Mutex
+	clients map[int64]*github.Client
+}
+
+// newApp creates a GitHub App authenticator from its ID and PEM private key
+func newApp(ctx context.Context, appID int64, key *rsa.PrivateKey) *app {
+	a := &app{
+		id:      appID,
+		key:     key,
+		baseCtx: ctx,
+		clients: make(map[int64]*github.Client),
+	}
+	a.api = github.NewClient(&http.Client{Transport: tracing.Transport("github", &jwtTransport{app: a})})
+	return a
+}
+
+// client returns the API client for an installation, creating it on first use
+func (a *app) client(installationID int64) *github.Client {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.clients[installationID]; ok {
+		return c
+	}
+	slog.Debug("Creating GitHub client for installation", "installation_id", installationID)
+	src := &installationTokenSource{app: a, installationID: installationID}
+	c := github.NewClient(&http.Client{Transport: tracing.Transport("github", &oauth2.Transport{Source: oauth2.ReuseTokenSource(nil, src)})})
+	a.clients[installationID] = c
+	return c
+}
+
+// jwt signs a short-lived RS256 JSON Web Token identifying the app
+func (a *app) jwt() (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(), // Allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign app JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// jwtTransport authenticates requests with a freshly signed app JWT
+type jwtTransport struct {
+	app *app
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.app.jwt()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// installationTokenSource mints installation access tokens; oauth2 caches each token until Expiry
+type installationTokenSource struct {
+	app            *app
+	installationID int64
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	slog.Debug("Minting installation access token", "installation_id", s.installationID)
+	tok, _, err := s.app.api.Apps.CreateInstallationToken(s.app.baseCtx, s.installationID, nil)
+	if err != nil {
+		slog.Error("Failed to mint installation access token", "installation_id", s.installationID, "error", err)
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: tok.GetToken(),
+		TokenType:   "token",
+		Expiry:      tok.GetExpiresAt().Add(-tokenRefreshMargin),
+	}, nil
+}
+
+// loadPrivateKey reads the app's PEM private key from a file path, or decodes it
+// from a base64 string when the value cannot be read as a file
+func loadPrivateKey(pathOrBase64 string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(pathOrBase64)
+	if err != nil {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(pathOrBase64))
+		if decodeErr != nil {
+			return nil, fmt.Errorf("read private key: %w", err)
+		}
+		data = decoded
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
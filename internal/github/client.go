@@ -3,80 +3,347 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"text/template"
 
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
 	"github.com/google/go-github/v62/github"
 	"golang.org/x/oauth2"
 )
 
-// Client provides GitHub API operations
+// Client provides GitHub API operations.
+// As a GitHub App it acts as the installation set on the context with WithInstallation,
+// falling back to GITHUB_INSTALLATION_ID and then to the personal access token.
 type Client struct {
-	client *github.Client
+	app                 *app           // nil unless GITHUB_APP_ID is set
+	defaultInstallation int64          // GITHUB_INSTALLATION_ID, used when a webhook has no installation
+	pat                 *github.Client // nil unless GITHUB_PAT is set
 }
 
-// NewClient creates a new GitHub client
-func NewClient(ctx context.Context) *Client {
-	log.Printf("DEBUG: Initializing GitHub client")
-	pat := os.Getenv("GITHUB_PAT")
-	if pat == "" {
-		log.Fatalf("ERROR: GITHUB_PAT environment variable is not set")
+// NewClient creates a new GitHub client from GITHUB_APP_ID / GITHUB_PRIVATE_KEY_PATH
+// (GitHub App) and/or GITHUB_PAT (personal access token)
+func NewClient(ctx context.Context) (*Client, error) {
+	c := &Client{}
+
+	if appID := os.Getenv("GITHUB_APP_ID"); appID != "" {
+		id, err := strconv.ParseInt(appID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_APP_ID %q: %w", appID, err)
+		}
+		keyPath := os.Getenv("GITHUB_PRIVATE_KEY_PATH")
+		if keyPath == "" {
+			return nil, errors.New("GITHUB_PRIVATE_KEY_PATH must be set with GITHUB_APP_ID")
+		}
+		key, err := loadPrivateKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load GitHub App private key: %w", err)
+		}
+		c.app = newApp(ctx, id, key)
+		if inst := os.Getenv("GITHUB_INSTALLATION_ID"); inst != "" {
+			if c.defaultInstallation, err = strconv.ParseInt(inst, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid GITHUB_INSTALLATION_ID %q: %w", inst, err)
+			}
+		}
+		slog.Info("GitHub App authentication enabled", "app_id", id, "default_installation", c.defaultInstallation)
+	}
+
+	if pat := os.Getenv("GITHUB_PAT"); pat != "" {
+		slog.Info("GitHub personal access token authentication enabled")
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: pat})
+		c.pat = github.NewClient(&http.Client{Transport: tracing.Transport("github", &oauth2.Transport{Source: ts})})
+	}
+
+	if c.app == nil && c.pat == nil {
+		return nil, errors.New("no GitHub credentials: set GITHUB_APP_ID and GITHUB_PRIVATE_KEY_PATH, or GITHUB_PAT")
+	}
+	return c, nil
+}
+
+// api returns the API client to use for ctx
+func (c *Client) api(ctx context.Context) (*github.Client, error) {
+	if c.app != nil {
+		id := installationFrom(ctx)
+		if id == 0 {
+			id = c.defaultInstallation
+		}
+		if id != 0 {
+			return c.app.client(id), nil
+		}
+	}
+	if c.pat != nil {
+		return c.pat, nil
+	}
+	return nil, errors.New("no GitHub installation for this request: the webhook has no installation.id and GITHUB_INSTALLATION_ID is not set")
+}
+
+// Ping checks that the GitHub API is reachable with the configured credentials
+func (c *Client) Ping(ctx context.Context) error {
+	if c.app != nil {
+		_, _, err := c.app.api.Apps.Get(ctx, "")
+		return err
 	}
-	log.Printf("DEBUG: GitHub PAT found (length: %d)", len(pat))
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: pat})
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
-	log.Printf("DEBUG: GitHub client initialized successfully")
-	return &Client{client: client}
+	_, _, err := c.pat.RateLimit.Get(ctx)
+	return err
 }
 
 // CreateIssueComment posts a comment on a GitHub issue
 func (c *Client) CreateIssueComment(ctx context.Context, owner, repo string, issueNumber int, body string) error {
-	log.Printf("DEBUG: Posting comment to %s/%s#%d", owner, repo, issueNumber)
-	_, _, err := c.client.Issues.CreateComment(ctx, owner, repo, issueNumber, &github.IssueComment{Body: &body})
+	slog.DebugContext(ctx, "Posting comment", "repo", owner+"/"+repo, "issue", issueNumber)
+	api, err := c.api(ctx)
+	if err != nil {
+		return err
+	}
+	_, _, err = api.Issues.CreateComment(ctx, owner, repo, issueNumber, &github.IssueComment{Body: &body})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create comment", "repo", owner+"/"+repo, "issue", issueNumber, "error", err)
+		return err
+	}
+	return nil
+}
+
+const (
+	// commentMarker is embedded in every DupRadar comment so it can be found and edited later
+	commentMarker = "<!-- dup-radar -->"
+	// commentFooter closes every DupRadar comment (also identifies comments posted before the marker existed)
+	commentFooter = "_Comment generated by DupRadar_"
+)
+
+// FindDupRadarComment returns DupRadar's own comment on an issue, or nil if there is none
+func (c *Client) FindDupRadarComment(ctx context.Context, owner, repo string, issueNumber int) (*github.IssueComment, error) {
+	slog.DebugContext(ctx, "Looking for an existing DupRadar comment", "repo", owner+"/"+repo, "issue", issueNumber)
+	api, err := c.api(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := api.Issues.ListComments(ctx, owner, repo, issueNumber, opts)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list comments", "repo", owner+"/"+repo, "issue", issueNumber, "error", err)
+			return nil, err
+		}
+		for _, cm := range comments {
+			if strings.Contains(cm.GetBody(), commentMarker) || strings.Contains(cm.GetBody(), commentFooter) {
+				return cm, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// EditIssueComment replaces the body of an existing issue comment
+func (c *Client) EditIssueComment(ctx context.Context, owner, repo string, commentID int64, body string) error {
+	slog.DebugContext(ctx, "Editing comment", "repo", owner+"/"+repo, "comment_id", commentID)
+	api, err := c.api(ctx)
+	if err != nil {
+		return err
+	}
+	_, _, err = api.Issues.EditComment(ctx, owner, repo, commentID, &github.IssueComment{Body: &body})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to edit comment", "repo", owner+"/"+repo, "comment_id", commentID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// AddLabels adds labels to a GitHub issue; labels that do not exist yet are created by GitHub
+func (c *Client) AddLabels(ctx context.Context, owner, repo string, issueNumber int, labels []string) error {
+	slog.DebugContext(ctx, "Adding labels", "repo", owner+"/"+repo, "issue", issueNumber, "labels", labels)
+	api, err := c.api(ctx)
+	if err != nil {
+		return err
+	}
+	_, _, err = api.Issues.AddLabelsToIssue(ctx, owner, repo, issueNumber, labels)
 	if err != nil {
-		log.Printf("ERROR: Failed to create comment on issue #%d: %v", issueNumber, err)
+		slog.ErrorContext(ctx, "Failed to add labels", "repo", owner+"/"+repo, "issue", issueNumber, "error", err)
 		return err
 	}
-	log.Printf("DEBUG: Successfully posted comment to issue #%d", issueNumber)
 	return nil
 }
 
-// BuildSimilarIssuesComment creates a comment with similar issues information
-func BuildSimilarIssuesComment(similarity float64, ids []int64, dists []float64) string {
-	log.Printf("DEBUG: Building comment with %d issue IDs and %d distances", len(ids), len(dists))
-	
-	if len(ids) == 0 {
-		log.Printf("DEBUG: No similar issues found, returning empty comment")
+// commentMessages holds the localized text of DupRadar's comments
+type commentMessages struct {
+	heading    string
+	noLonger   string
+	distance   string
+	dotProduct string
+	closed     string
+}
+
+// messages maps a comment language to its text; unknown languages fall back to Japanese
+var messages = map[string]commentMessages{
+	"ja": {
+		heading:    "### 🤖 類似 Issue 候補",
+		noLonger:   "編集後の内容では類似 Issue は見つかりませんでした。",
+		distance:   "距離",
+		dotProduct: "内積",
+		closed:     "クローズ済み",
+	},
+	"en": {
+		heading:    "### 🤖 Possible duplicate issues",
+		noLonger:   "No similar issues were found for the edited content.",
+		distance:   "distance",
+		dotProduct: "dot product",
+		closed:     "closed",
+	},
+}
+
+// messagesFor returns the comment text for lang
+func messagesFor(lang string) commentMessages {
+	if m, ok := messages[strings.ToLower(lang)]; ok {
+		return m
+	}
+	return messages["ja"]
+}
+
+// CommentOptions controls how a similar-issues comment is built
+type CommentOptions struct {
+	// DotProduct reports that scores are dot products, where higher is more similar and
+	// the threshold is a minimum; otherwise they are distances and the threshold a maximum
+	DotProduct bool
+	Threshold  float64
+	Repo       string // Repository of the commented issue; its issues are linked as #N
+	Language   string // ja (default) or en
+	Template   string // text/template for the comment body (see CommentData); empty uses the built-in layout
+}
+
+// Candidate is a similar issue found by the vector search
+type Candidate struct {
+	Repo        string
+	Number      int64
+	Score       float64 // Distance, or dot product if CommentOptions.DotProduct is set
+	Closed      bool
+	StateReason string
+}
+
+// withinThreshold reports whether score is similar enough to be listed
+func (o CommentOptions) withinThreshold(score float64) bool {
+	if o.DotProduct {
+		return score >= o.Threshold
+	}
+	return score <= o.Threshold
+}
+
+// CommentData is passed to a custom comment template
+type CommentData struct {
+	Repo       string         // Repository of the commented issue
+	ScoreLabel string         // Localized caption of the score, e.g. 距離
+	Issues     []CommentIssue // Candidates within the threshold
+}
+
+// CommentIssue is one candidate in CommentData
+type CommentIssue struct {
+	Ref         string // #N, or owner/repo#N for other repositories
+	Repo        string
+	Number      int64
+	Score       float64
+	Closed      bool
+	StateReason string
+}
+
+// BuildNoLongerSimilarComment creates the replacement for a DupRadar comment
+// when an edited issue no longer has similar issues
+func BuildNoLongerSimilarComment(lang string) string {
+	msg := messagesFor(lang)
+	return commentMarker + "\n" + msg.heading + "\n\n" + msg.noLonger + "\n\n" + commentFooter + "\n"
+}
+
+// BuildSimilarIssuesComment creates a comment with similar issues information.
+// Scores are compared with the threshold and labelled as distances or dot products.
+// Issues from opts.Repo are linked as #N; issues from other repositories as owner/repo#N.
+func BuildSimilarIssuesComment(opts CommentOptions, issues []Candidate) string {
+	if len(issues) == 0 {
 		return "" // no similar issues
 	}
-	
-	if len(dists) > 0 && dists[0] > similarity {
-		log.Printf("DEBUG: Most similar issue has distance %.4f which is above threshold %.4f, returning empty comment", 
-				  dists[0], similarity)
+
+	// Issues may be reordered (e.g. open issues first), so check every candidate
+	var matched []Candidate
+	for _, is := range issues {
+		if !opts.withinThreshold(is.Score) {
+			slog.Debug("Candidate is not within the threshold", "candidate", fmt.Sprintf("%s#%d", is.Repo, is.Number),
+				"dot_product", opts.DotProduct, "score", is.Score, "threshold", opts.Threshold)
+			continue
+		}
+		matched = append(matched, is)
+	}
+	if len(matched) == 0 {
 		return "" // not similar enough
 	}
-	
-	log.Printf("DEBUG: Found similar issues below threshold, creating comment")
-	var sb strings.Builder
-	sb.WriteString("### 🤖 類似 Issue 候補\n\n")
-	
-	issuesIncluded := 0
-	for i, id := range ids {
-		if i >= len(dists) || dists[i] > similarity {
-			log.Printf("DEBUG: Stopping at issue #%d with distance %.4f (above threshold %.4f)", 
-					  id, dists[i], similarity)
-			break
+
+	msg := messagesFor(opts.Language)
+	scoreLabel := msg.distance
+	if opts.DotProduct {
+		scoreLabel = msg.dotProduct
+	}
+	if opts.Template != "" {
+		body, err := renderCommentTemplate(opts, scoreLabel, matched)
+		if err == nil {
+			return commentMarker + "\n" + body + "\n\n" + commentFooter + "\n"
 		}
-		sb.WriteString(fmt.Sprintf("* #%d (距離 %.3f)\n", id, dists[i]))
-		issuesIncluded++
-		log.Printf("DEBUG: Added issue #%d with distance %.4f to comment", id, dists[i])
-	}
-	
-	sb.WriteString("\n_Comment generated by DupRadar_\n")
-	log.Printf("DEBUG: Created comment with %d similar issues", issuesIncluded)
-	
+		slog.Error("Failed to render comment template, using the default layout", "error", err)
+	}
+	var sb strings.Builder
+	sb.WriteString(commentMarker + "\n")
+	sb.WriteString(msg.heading + "\n\n")
+
+	for _, is := range matched {
+		sb.WriteString(fmt.Sprintf("* %s (%s %.3f)%s\n", issueRef(opts.Repo, is), scoreLabel, is.Score, closedNote(is, msg)))
+	}
+
+	sb.WriteString("\n" + commentFooter + "\n")
+
 	return sb.String()
 }
+
+// renderCommentTemplate renders the body of a comment with a custom template.
+// The marker and footer are added by the caller so the comment can still be found and edited.
+func renderCommentTemplate(opts CommentOptions, scoreLabel string, issues []Candidate) (string, error) {
+	tmpl, err := template.New("comment").Parse(opts.Template)
+	if err != nil {
+		return "", err
+	}
+	data := CommentData{Repo: opts.Repo, ScoreLabel: scoreLabel}
+	for _, is := range issues {
+		data.Issues = append(data.Issues, CommentIssue{
+			Ref:         issueRef(opts.Repo, is),
+			Repo:        is.Repo,
+			Number:      is.Number,
+			Score:       is.Score,
+			Closed:      is.Closed,
+			StateReason: is.StateReason,
+		})
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// issueRef renders a GitHub issue reference relative to repo
+func issueRef(repo string, is Candidate) string {
+	if is.Repo == "" || strings.EqualFold(is.Repo, repo) {
+		return fmt.Sprintf("#%d", is.Number)
+	}
+	return fmt.Sprintf("%s#%d", is.Repo, is.Number)
+}
+
+// closedNote annotates closed issues in the comment, including GitHub's state reason
+func closedNote(is Candidate, msg commentMessages) string {
+	if !is.Closed {
+		return ""
+	}
+	if is.StateReason == "" {
+		return " — " + msg.closed
+	}
+	return fmt.Sprintf(" — %s (%s)", msg.closed, is.StateReason)
+}
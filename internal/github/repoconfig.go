@@ -0,0 +1,125 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/google/go-github/v62/github"
+)
+
+// GetRepoFile returns the content of a file on the repository's default branch,
+// or nil if the file does not exist
+func (c *Client) GetRepoFile(ctx context.Context, owner, repo, path string) ([]byte, error) {
+	slog.DebugContext(ctx, "Fetching repository file", "repo", owner+"/"+repo, "path", path)
+	api, err := c.api(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file, _, resp, err := api.Repositories.GetContents(ctx, owner, repo, path, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp != nil && resp.StatusCode == http.StatusForbidden && !isRateLimit(err) {
+		// The app lacks the Contents permission; behave as if the file did not exist
+		slog.ErrorContext(ctx, "No permission to read repository file (grant Contents: read)", "repo", owner+"/"+repo, "path", path, "error", err)
+		return nil, nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to fetch repository file", "repo", owner+"/"+repo, "path", path, "error", err)
+		return nil, err
+	}
+	if file == nil {
+		return nil, nil // path is a directory
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// isRateLimit reports whether err is a (secondary) rate limit, which is worth retrying
+func isRateLimit(err error) bool {
+	var rl *github.RateLimitError
+	var abuse *github.AbuseRateLimitError
+	return errors.As(err, &rl) || errors.As(err, &abuse)
+}
+
+// RepoConfigs caches each repository's .github/dup-radar.yml.
+// Entries are dropped by Invalidate on default-branch pushes and expire after a TTL
+// in case push events are not delivered.
+type RepoConfigs struct {
+	gh       *Client
+	ttl      time.Duration
+	distance string // Distance metric the thresholds are checked against
+
+	mu      sync.Mutex
+	entries map[string]repoConfigEntry // Keyed by lower-case owner/repo
+	gens    map[string]uint64          // Invalidations per key; fetches started before one are not cached
+}
+
+type repoConfigEntry struct {
+	file      *config.RepoFile // nil when the repository has no (valid) file
+	fetchedAt time.Time
+}
+
+// NewRepoConfigs creates an in-repo configuration cache; ttl <= 0 uses 10 minutes.
+// Similarity thresholds in the files are checked against the distance metric.
+// Both are fixed for the life of the cache, so changing github.repo_config_ttl needs a restart.
+func NewRepoConfigs(gh *Client, ttl time.Duration, distance string) *RepoConfigs {
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &RepoConfigs{gh: gh, ttl: ttl, distance: distance, entries: make(map[string]repoConfigEntry), gens: make(map[string]uint64)}
+}
+
+// Get returns the parsed .github/dup-radar.yml of owner/repo, or nil if there is none.
+// A file that fails to parse is logged and treated as absent so it cannot block processing.
+func (r *RepoConfigs) Get(ctx context.Context, owner, repo string) (*config.RepoFile, error) {
+	key := strings.ToLower(owner + "/" + repo)
+	r.mu.Lock()
+	e, ok := r.entries[key]
+	gen := r.gens[key]
+	r.mu.Unlock()
+	if ok && time.Since(e.fetchedAt) < r.ttl {
+		return e.file, nil
+	}
+
+	data, err := r.gh.GetRepoFile(ctx, owner, repo, config.RepoFilePath)
+	if err != nil {
+		return nil, err
+	}
+	var file *config.RepoFile
+	if data != nil {
+		if file, err = config.ParseRepoFile(data, r.distance); err != nil {
+			slog.ErrorContext(ctx, "Ignoring invalid repository config", "repo", owner+"/"+repo, "path", config.RepoFilePath, "error", err)
+			file = nil
+		} else {
+			slog.DebugContext(ctx, "Loaded repository config", "repo", owner+"/"+repo, "path", config.RepoFilePath)
+		}
+	}
+
+	r.mu.Lock()
+	// A push invalidated the file while it was being fetched; the result may predate the push
+	if r.gens[key] == gen {
+		r.entries[key] = repoConfigEntry{file: file, fetchedAt: time.Now()}
+	}
+	r.mu.Unlock()
+	return file, nil
+}
+
+// Invalidate drops the cached file of a repository (owner/repo)
+func (r *RepoConfigs) Invalidate(repoFull string) {
+	key := strings.ToLower(repoFull)
+	r.mu.Lock()
+	delete(r.entries, key)
+	r.gens[key]++
+	r.mu.Unlock()
+	slog.Info("Invalidated cached repository config", "repo", repoFull)
+}
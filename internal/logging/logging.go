@@ -0,0 +1,123 @@
+// Package logging configures the process-wide log/slog logger: JSON (or text) output,
+// a level that can be changed at runtime, fields carried on the context and redaction.
+//
+// Records use these field names across packages: delivery_id (X-GitHub-Delivery),
+// repo (owner/repo), issue (number), step (pipeline step), duration (seconds) and error.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var level = new(slog.LevelVar)
+
+// Setup installs the default logger writing to stdout. format is "json" or "text",
+// level is one of debug, info, warn or error.
+func Setup(format, lvl string) error {
+	return setup(os.Stdout, format, lvl)
+}
+
+func setup(w io.Writer, format, lvl string) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceAttr}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(&contextHandler{Handler: h}))
+	return nil
+}
+
+// SetLevel changes the minimum level of the default logger
+func SetLevel(lvl string) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// ParseLevel parses debug, info, warn or error (case-insensitive); empty means info
+func ParseLevel(lvl string) (slog.Level, error) {
+	var l slog.Level
+	if lvl == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(lvl)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", lvl)
+	}
+	return l, nil
+}
+
+type attrsKey struct{}
+
+// With returns a context whose log records carry the given key/value pairs,
+// in addition to those already on ctx (later values of a key replace earlier ones)
+func With(ctx context.Context, args ...any) context.Context {
+	prev, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	attrs := make([]slog.Attr, 0, len(prev)+r.NumAttrs())
+	attrs = append(attrs, prev...)
+	r.Attrs(func(a slog.Attr) bool {
+		for i := range attrs {
+			if attrs[i].Key == a.Key {
+				attrs = append(attrs[:i:i], attrs[i+1:]...)
+				break
+			}
+		}
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// contextHandler adds the fields stored with With and the current trace and span IDs
+// to records logged with a context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		// Fields given at the call site win over the same fields on the context
+		seen := make(map[string]bool, r.NumAttrs())
+		r.Attrs(func(a slog.Attr) bool {
+			seen[a.Key] = true
+			return true
+		})
+		for _, a := range attrs {
+			if !seen[a.Key] {
+				r.AddAttrs(a)
+			}
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"log/slog"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are field names (or suffixes such as issue_body) whose values are never
+// written: credentials and issue or comment text, which may contain personal data
+var sensitiveKeys = []string{"secret", "token", "password", "signature", "authorization", "private_key", "api_key", "dsn", "body"}
+
+// secretPrefixes mark values that look like credentials wherever they appear
+var secretPrefixes = []string{"ghp_", "gho_", "ghs_", "ghu_", "ghr_", "github_pat_", "Bearer ", "-----BEGIN"}
+
+// replaceAttr redacts sensitive fields and renders durations in seconds
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, s := range sensitiveKeys {
+		if key == s || strings.HasSuffix(key, "_"+s) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		return slog.Float64(a.Key, a.Value.Duration().Seconds())
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// Redact replaces every credential-looking word in s
+func Redact(s string) string {
+	for _, p := range secretPrefixes {
+		for i := strings.Index(s, p); i >= 0; i = strings.Index(s, p) {
+			if p == "-----BEGIN" {
+				s = s[:i] + redacted // A PEM block runs to the end
+				break
+			}
+			end := i + len(p)
+			for end < len(s) && !strings.ContainsRune(" \t\n\"',", rune(s[end])) {
+				end++
+			}
+			s = s[:i] + redacted + s[end:]
+		}
+	}
+	return s
+}
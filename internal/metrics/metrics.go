@@ -0,0 +1,128 @@
+// Package metrics defines the Prometheus metrics of the duplicate detection pipeline
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dupradar"
+
+// Outcomes of a webhook delivery, used as the outcome label of WebhookDeliveries
+const (
+	OutcomeQueued           = "queued"            // Accepted and queued for processing
+	OutcomeDuplicate        = "duplicate"         // Redelivery of an event already accepted
+	OutcomeIgnored          = "ignored"           // Event or action that needs no work
+	OutcomeHandled          = "handled"           // Handled synchronously (push events)
+	OutcomeInvalidSignature = "invalid_signature" // Missing or wrong X-Hub-Signature-256
+	OutcomeBadRequest       = "bad_request"       // Unreadable body or unparsable payload
+	OutcomeError            = "error"             // Failed to enqueue
+)
+
+var (
+	// WebhookDeliveries counts webhook deliveries by event, action and outcome
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook deliveries by event type, action and outcome.",
+	}, []string{"event", "action", "outcome"})
+
+	// SignatureFailures counts deliveries rejected by signature verification
+	SignatureFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_signature_failures_total",
+		Help:      "Webhook deliveries rejected because the signature was missing or invalid.",
+	}, []string{"reason"})
+
+	// EmbeddingDuration observes embedding API latency
+	EmbeddingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "embedding_request_duration_seconds",
+		Help:      "Latency of embedding API requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10), // 50ms .. ~25s
+	}, []string{"task_type", "outcome"})
+
+	// EmbeddingTokens observes the number of input tokens reported per embedded text
+	EmbeddingTokens = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "embedding_input_tokens",
+		Help:      "Input tokens per embedded text as reported by the embedding API.",
+		Buckets:   prometheus.ExponentialBuckets(16, 2, 10), // 16 .. 8192
+	})
+
+	// EmbeddingChunks observes the number of chunks an issue is embedded as
+	EmbeddingChunks = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "embedding_chunks",
+		Help:      "Chunks per embedded issue.",
+		Buckets:   []float64{1, 2, 3, 4, 6, 8, 12, 16, 32},
+	})
+
+	// EmbeddingCacheRequests counts embedding cache lookups by result (hit or miss)
+	EmbeddingCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "embedding_cache_requests_total",
+		Help:      "Embedding cache lookups by result.",
+	}, []string{"result"})
+
+	// EmbeddingCacheEntries reports the number of embeddings held in the cache
+	EmbeddingCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "embedding_cache_entries",
+		Help:      "Embeddings held in the embedding cache.",
+	})
+
+	// EmbeddingTruncations counts texts the embedding API truncated to the model's input limit
+	EmbeddingTruncations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "embedding_truncations_total",
+		Help:      "Embedded texts that were truncated to the model's input limit.",
+	})
+
+	// SearchDuration observes vector search latency
+	SearchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vector_search_duration_seconds",
+		Help:      "Latency of similar-issue vector searches.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "outcome"})
+
+	// SearchDistances observes the distance of each similar-issue candidate
+	SearchDistances = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vector_search_result_distance",
+		Help:      "Distance of similar-issue candidates returned by vector searches.",
+		// -2 .. 2 covers COSINE (0 .. 2), EUCLIDEAN and the negated DOT_PRODUCT of unit vectors
+		Buckets: prometheus.LinearBuckets(-2, 0.1, 41),
+	}, []string{"distance_type"})
+
+	// CommentsPosted counts similar-issue comments created or edited on GitHub
+	CommentsPosted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "comments_posted_total",
+		Help:      "Similar-issue comments written to GitHub, by whether they were created or edited.",
+	}, []string{"kind"})
+
+	// InsertFailures counts failed attempts to store an issue vector
+	InsertFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vector_insert_failures_total",
+		Help:      "Failed attempts to store an issue vector, including attempts that were retried.",
+	}, []string{"backend"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Outcome returns the outcome label value for an operation that returned err
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
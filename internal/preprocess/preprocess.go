@@ -0,0 +1,215 @@
+// Package preprocess normalizes issue text before it is embedded. The same steps run when
+// an issue is indexed and when it is searched with, so that both embeddings see the same text.
+package preprocess
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+)
+
+var (
+	commentRe   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	imageRe     = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)|<img\b[^>]*>`)
+	checkboxRe  = regexp.MustCompile(`^\s*[-*+]\s+\[ \](\s|$)`)
+	headingRe   = regexp.MustCompile(`^(#{1,6})\s`)
+	fenceRe     = regexp.MustCompile("^\\s*(`{3,}|~{3,})")
+	replyRe     = regexp.MustCompile(`^On .+ wrote:$`)
+	spacesRe    = regexp.MustCompile(`[ \t]+`)
+	footerLines = []string{"Sent from my ", "Get Outlook for ", "Reply to this email directly", "You are receiving this because"}
+	// The answer GitHub issue forms leave in optional fields nobody filled in
+	placeholder = "_no response_"
+)
+
+// line is one line of the body; code lines are left alone by the text steps
+type line struct {
+	text string
+	code bool
+}
+
+// Issue returns the title and body of an issue as configured in embedding.preprocess.
+// The title is repeated title_weight times.
+func Issue(cfg *config.Config, title, body string) (string, string) {
+	p := cfg.Embedding.Preprocess
+	if p.NormalizeWhitespace {
+		title = strings.Join(strings.Fields(title), " ")
+	}
+	if p.TitleWeight > 1 {
+		title = strings.TrimSuffix(strings.Repeat(title+"\n", p.TitleWeight), "\n")
+	}
+
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if p.StripComments {
+		body = commentRe.ReplaceAllString(body, "")
+	}
+	lines := codeBlocks(strings.Split(body, "\n"), strings.ToLower(p.CodeBlocks), p.CodeBlockLines)
+	if p.StripSignatures {
+		lines = stripSignature(lines)
+	}
+	if p.StripImages {
+		for i := range lines {
+			if !lines[i].code {
+				lines[i].text = imageRe.ReplaceAllString(lines[i].text, "")
+			}
+		}
+	}
+	if p.StripTemplate {
+		lines = stripTemplate(lines)
+	}
+	return title, join(lines, p.NormalizeWhitespace)
+}
+
+// codeBlocks marks the lines of fenced code blocks and keeps them (mode keep), replaces
+// them with their first n lines and the number of lines left out (summarize), or drops them
+func codeBlocks(in []string, mode string, n int) []line {
+	out := make([]line, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		m := fenceRe.FindStringSubmatch(in[i])
+		if m == nil {
+			out = append(out, line{text: in[i]})
+			continue
+		}
+		// The block ends at a fence of the same kind and at least the same length, or at the end
+		end := len(in)
+		for j := i + 1; j < len(in); j++ {
+			if f := fenceRe.FindStringSubmatch(in[j]); f != nil && f[1][0] == m[1][0] && len(f[1]) >= len(m[1]) && strings.TrimSpace(in[j]) == f[1] {
+				end = j
+				break
+			}
+		}
+		content := in[i+1 : end]
+		switch mode {
+		case "drop":
+		case "keep":
+			for _, l := range content {
+				out = append(out, line{text: l, code: true})
+			}
+		default:
+			for _, l := range content[:min(n, len(content))] {
+				out = append(out, line{text: l, code: true})
+			}
+			if len(content) > n {
+				out = append(out, line{text: fmt.Sprintf("(%d more lines)", len(content)-n), code: true})
+			}
+		}
+		i = end
+	}
+	return out
+}
+
+// stripSignature removes email signatures (from a "-- " line on), quoted replies and
+// the footers of mail clients and GitHub notifications
+func stripSignature(in []line) []line {
+	out := make([]line, 0, len(in))
+	quoted := false
+	for _, l := range in {
+		if l.code {
+			out = append(out, l)
+			continue
+		}
+		if l.text == "-- " {
+			break
+		}
+		t := strings.TrimSpace(l.text)
+		if replyRe.MatchString(t) {
+			quoted = true
+			continue
+		}
+		if quoted && strings.HasPrefix(t, ">") {
+			continue
+		}
+		quoted = false
+		if hasAnyPrefix(t, footerLines) {
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// stripTemplate removes unchecked checkbox lines and the headings of sections that were left
+// empty or with the placeholder answer. Checked boxes are answers and are kept, as is a heading
+// directly followed by a deeper one.
+func stripTemplate(in []line) []line {
+	var kept []line
+	for _, l := range in {
+		if !l.code && checkboxRe.MatchString(l.text) {
+			continue
+		}
+		kept = append(kept, l)
+	}
+
+	out := make([]line, 0, len(kept))
+	for i := 0; i < len(kept); {
+		level := headingLevel(kept[i])
+		if level == 0 {
+			out = append(out, kept[i])
+			i++
+			continue
+		}
+		end := i + 1
+		for end < len(kept) && headingLevel(kept[end]) == 0 {
+			end++
+		}
+		if end < len(kept) && headingLevel(kept[end]) > level || !emptySection(kept[i+1:end]) {
+			out = append(out, kept[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
+
+// headingLevel returns the level of a markdown heading line, or 0
+func headingLevel(l line) int {
+	if l.code {
+		return 0
+	}
+	if m := headingRe.FindStringSubmatch(l.text); m != nil {
+		return len(m[1])
+	}
+	return 0
+}
+
+// emptySection reports whether a section has no content other than the placeholder
+func emptySection(lines []line) bool {
+	var content []string
+	for _, l := range lines {
+		if t := strings.TrimSpace(l.text); t != "" {
+			content = append(content, t)
+		}
+	}
+	return len(content) == 0 || len(content) == 1 && strings.EqualFold(content[0], placeholder)
+}
+
+// join joins lines, trimming trailing spaces and, if normalize is set, collapsing runs of
+// spaces outside code and of blank lines
+func join(lines []line, normalize bool) string {
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		t := strings.TrimRight(l.text, " \t")
+		if normalize {
+			if !l.code {
+				t = strings.TrimSpace(spacesRe.ReplaceAllString(t, " "))
+			}
+			if t == "" && (len(out) == 0 || out[len(out)-1] == "") {
+				continue
+			}
+		}
+		out = append(out, t)
+	}
+	if normalize {
+		return strings.Trim(strings.Join(out, "\n"), "\n")
+	}
+	return strings.Join(out, "\n")
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package preprocess
+
+import (
+	"testing"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+)
+
+// allSteps enables every step with the shipped defaults
+func allSteps() *config.Config {
+	cfg := &config.Config{}
+	p := &cfg.Embedding.Preprocess
+	p.StripComments = true
+	p.StripTemplate = true
+	p.CodeBlocks = "summarize"
+	p.CodeBlockLines = 2
+	p.StripImages = true
+	p.StripSignatures = true
+	p.NormalizeWhitespace = true
+	p.TitleWeight = 1
+	return cfg
+}
+
+func TestIssue(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       func(*config.Config)
+		title     string
+		body      string
+		wantTitle string
+		wantBody  string
+	}{
+		{
+			name:      "whitespace",
+			title:     "  Crash   on\tstart ",
+			body:      "First  line\r\n\r\n\r\nSecond\t line  ",
+			wantTitle: "Crash on start",
+			wantBody:  "First line\n\nSecond line",
+		},
+		{
+			name:      "title weight",
+			cfg:       func(c *config.Config) { c.Embedding.Preprocess.TitleWeight = 2 },
+			title:     "Crash",
+			wantTitle: "Crash\nCrash",
+		},
+		{
+			name:     "comments and images",
+			body:     "<!-- Describe the bug -->\nSee ![screenshot](https://x/y.png) and <img src=\"a.png\">here",
+			wantBody: "See and here",
+		},
+		{
+			name:     "unchecked boxes are dropped, checked ones kept",
+			body:     "- [ ] I searched existing issues\n- [x] I use the latest version\n* [X] Linux",
+			wantBody: "- [x] I use the latest version\n* [X] Linux",
+		},
+		{
+			name:     "empty and no-response sections are dropped",
+			body:     "### Steps\n\n_No response_\n\n### Expected\n\n### Actual\n\nIt crashes",
+			wantBody: "### Actual\n\nIt crashes",
+		},
+		{
+			name:     "other short answers are content",
+			body:     "### Workaround\n\nN/A\n\n### Version\n\nnone",
+			wantBody: "### Workaround\n\nN/A\n\n### Version\n\nnone",
+		},
+		{
+			name:     "heading followed by a subsection is kept",
+			body:     "## Environment\n### OS\nmacOS",
+			wantBody: "## Environment\n### OS\nmacOS",
+		},
+		{
+			name:     "code blocks are summarized",
+			body:     "Log:\n```\nline 1\nline 2\nline 3\nline 4\n```\nAfter",
+			wantBody: "Log:\nline 1\nline 2\n(2 more lines)\nAfter",
+		},
+		{
+			name:     "code blocks are dropped",
+			cfg:      func(c *config.Config) { c.Embedding.Preprocess.CodeBlocks = "drop" },
+			body:     "Before\n~~~go\nx := 1\n~~~\nAfter",
+			wantBody: "Before\nAfter",
+		},
+		{
+			name:     "code keeps its spacing and checkboxes",
+			cfg:      func(c *config.Config) { c.Embedding.Preprocess.CodeBlocks = "keep" },
+			body:     "```\n  - [ ]  x\n```",
+			wantBody: "  - [ ]  x",
+		},
+		{
+			name:     "signatures and quoted replies",
+			body:     "Same here.\nOn Mon, Jan 1, 2024 someone wrote:\n> original\n> text\nSent from my iPhone\n-- \nJane",
+			wantBody: "Same here.",
+		},
+		{
+			name: "disabled steps leave the body alone",
+			cfg: func(c *config.Config) {
+				c.Embedding.Preprocess = allSteps().Embedding.Preprocess
+				c.Embedding.Preprocess.StripComments = false
+				c.Embedding.Preprocess.StripTemplate = false
+				c.Embedding.Preprocess.NormalizeWhitespace = false
+			},
+			body:     "<!-- c -->\n- [ ] box\n\n\nend",
+			wantBody: "<!-- c -->\n- [ ] box\n\n\nend",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := allSteps()
+			if tt.cfg != nil {
+				tt.cfg(cfg)
+			}
+			title, body := Issue(cfg, tt.title, tt.body)
+			if title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", title, tt.wantTitle)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
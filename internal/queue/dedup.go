@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrDuplicate is returned by Enqueue when the job ID or one of its keys was already accepted
+var ErrDuplicate = errors.New("queue: duplicate job")
+
+const (
+	// seenFile is an append-only log of the idempotency keys accepted and released,
+	// one JSON record per line, under the queue directory
+	seenFile = "seen.log"
+	// legacySeenFile is the key map written by earlier versions; it is converted on open
+	legacySeenFile = "seen.json"
+	// compactMin is the number of log records below which the log is never compacted
+	compactMin = 1024
+)
+
+// seenRecord is one line of the seen log
+type seenRecord struct {
+	Key      string    `json:"key"`
+	At       time.Time `json:"at,omitempty"`       // When the key was accepted
+	Released bool      `json:"released,omitempty"` // The key was forgotten
+}
+
+// idempotencyKeys returns the keys that identify a job: its ID and any extra Keys
+func (j *Job) idempotencyKeys() []string {
+	return append([]string{"id:" + j.ID}, j.Keys...)
+}
+
+// claim records the keys of job as accepted, or returns ErrDuplicate if any of them
+// was accepted within queue.dedup_ttl. Callers hold q.mu.
+func (q *Queue) claim(job *Job) error {
+	now := time.Now().UTC()
+	keys := job.idempotencyKeys()
+	for _, key := range keys {
+		if at, ok := q.seen[key]; ok && now.Sub(at) <= q.dedupTTL {
+			slog.Info("Skipping job matching an already accepted event", "delivery_id", job.ID, "key", key)
+			return ErrDuplicate
+		}
+	}
+	if err := q.accept(keys, now); err != nil {
+		// Not accepted: a redelivery must not be rejected as a duplicate
+		for _, key := range keys {
+			delete(q.seen, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// accept marks keys as accepted at time at and logs them. Callers hold q.mu.
+func (q *Queue) accept(keys []string, at time.Time) error {
+	recs := make([]seenRecord, len(keys))
+	for i, key := range keys {
+		q.seen[key] = at
+		recs[i] = seenRecord{Key: key, At: at}
+	}
+	return q.logSeen(recs)
+}
+
+// release forgets the keys of job so that a later delivery of the same event is processed again
+func (q *Queue) release(job *Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	keys := job.idempotencyKeys()
+	recs := make([]seenRecord, len(keys))
+	for i, key := range keys {
+		delete(q.seen, key)
+		recs[i] = seenRecord{Key: key, Released: true}
+	}
+	if err := q.logSeen(recs); err != nil {
+		slog.Error("Failed to release idempotency keys", "delivery_id", job.ID, "error", err)
+	}
+}
+
+// logSeen appends recs to the seen log, or rewrites the log from q.seen once most of its
+// records are outdated or keys may have expired since the last rewrite.
+// Callers hold q.mu and have already applied recs to q.seen.
+func (q *Queue) logSeen(recs []seenRecord) error {
+	if q.seenRecords+len(recs) > max(compactMin, 2*len(q.seen)) || time.Since(q.seenCompacted) > q.dedupTTL {
+		return q.compactSeen()
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("encode accepted job key: %w", err)
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(q.dir, seenFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open accepted job keys: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("write accepted job keys: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write accepted job keys: %w", err)
+	}
+	q.seenRecords += len(recs)
+	return nil
+}
+
+// compactSeen drops expired keys and atomically rewrites the seen log with one record
+// per remaining key. Callers hold q.mu.
+func (q *Queue) compactSeen() error {
+	now := time.Now().UTC()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for key, at := range q.seen {
+		if now.Sub(at) > q.dedupTTL {
+			delete(q.seen, key)
+			continue
+		}
+		if err := enc.Encode(seenRecord{Key: key, At: at}); err != nil {
+			return fmt.Errorf("encode accepted job key: %w", err)
+		}
+	}
+	path := filepath.Join(q.dir, seenFile)
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write accepted job keys: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("persist accepted job keys: %w", err)
+	}
+	q.seenRecords, q.seenCompacted = len(q.seen), now
+	slog.Debug("Compacted accepted job keys", "keys", len(q.seen))
+	return nil
+}
+
+// loadSeen replays the seen log of a previous run; a missing log means no keys.
+// A key map left by an earlier version is converted to a log.
+func (q *Queue) loadSeen() error {
+	q.seen = make(map[string]time.Time)
+	q.seenRecords, q.seenCompacted = 0, time.Now().UTC()
+	legacy := filepath.Join(q.dir, legacySeenFile)
+	data, err := os.ReadFile(legacy)
+	if err == nil {
+		if err := json.Unmarshal(data, &q.seen); err != nil {
+			return fmt.Errorf("decode accepted job keys: %w", err)
+		}
+		if err := q.compactSeen(); err != nil {
+			return err
+		}
+		return os.Remove(legacy)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read accepted job keys: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(q.dir, seenFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read accepted job keys: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec seenRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// A crash mid-append leaves a partial last line
+			slog.Warn("Skipping unreadable accepted job key record", "error", err)
+			continue
+		}
+		if rec.Released {
+			delete(q.seen, rec.Key)
+		} else {
+			q.seen[rec.Key] = rec.At
+		}
+		q.seenRecords++
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read accepted job keys: %w", err)
+	}
+	return nil
+}
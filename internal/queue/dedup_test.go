@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnqueueDedup(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   Job
+		second  Job
+		reopen  bool // Enqueue the second job after reopening the queue
+		wantErr error
+	}{
+		{"same delivery", Job{ID: "d1"}, Job{ID: "d1"}, false, ErrDuplicate},
+		{"same event key", Job{ID: "d1", Keys: []string{"o/r#1:opened"}}, Job{ID: "d2", Keys: []string{"o/r#1:opened"}}, false, ErrDuplicate},
+		{"different events", Job{ID: "d1", Keys: []string{"o/r#1:opened"}}, Job{ID: "d2", Keys: []string{"o/r#1:edited"}}, false, nil},
+		{"keys survive a restart", Job{ID: "d1"}, Job{ID: "d1"}, true, ErrDuplicate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			q := openQueue(t, cfg)
+			if err := q.Enqueue(&tt.first); err != nil {
+				t.Fatalf("first Enqueue: %v", err)
+			}
+			if tt.reopen {
+				q = openQueue(t, cfg)
+			}
+			if err := q.Enqueue(&tt.second); !errors.Is(err, tt.wantErr) {
+				t.Errorf("second Enqueue error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnqueueReleasesKeysWhenNotAccepted(t *testing.T) {
+	cfg := testConfig(t)
+	q := openQueue(t, cfg)
+	// Without the queue directory the accepted keys cannot be saved
+	if err := os.RemoveAll(cfg.Queue.Dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(&Job{ID: "d1"}); err == nil {
+		t.Fatal("Enqueue succeeded without a queue directory")
+	}
+	if err := os.MkdirAll(filepath.Join(cfg.Queue.Dir, "pending"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(&Job{ID: "d1"}); err != nil {
+		t.Errorf("redelivery after a failed Enqueue: %v, want it accepted", err)
+	}
+}
+
+func TestSeenLogIsCompacted(t *testing.T) {
+	cfg := testConfig(t)
+	q := openQueue(t, cfg)
+	// Accepting and releasing keys only grows the log until it is compacted
+	for i := 0; i < compactMin; i++ {
+		job := &Job{ID: fmt.Sprintf("d%d", i)}
+		q.mu.Lock()
+		err := q.claim(job)
+		q.mu.Unlock()
+		if err != nil {
+			t.Fatalf("claim %s: %v", job.ID, err)
+		}
+		q.release(job)
+	}
+	if err := q.Enqueue(&Job{ID: "kept"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.Queue.Dir, seenFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines >= compactMin {
+		t.Errorf("seen log has %d records after compaction, want fewer than %d", lines, compactMin)
+	}
+	q = openQueue(t, cfg)
+	if err := q.Enqueue(&Job{ID: "kept"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Enqueue(kept) after reopening error = %v, want ErrDuplicate", err)
+	}
+	if err := q.Enqueue(&Job{ID: "d0"}); err != nil {
+		t.Errorf("Enqueue(released key) after reopening: %v", err)
+	}
+}
+
+func TestLegacySeenFile(t *testing.T) {
+	cfg := testConfig(t)
+	seen := map[string]time.Time{
+		"id:recent":  time.Now().UTC().Add(-time.Hour),
+		"id:expired": time.Now().UTC().Add(-100 * time.Hour), // Past the default queue.dedup_ttl
+	}
+	data, _ := json.Marshal(seen)
+	if err := os.WriteFile(filepath.Join(cfg.Queue.Dir, legacySeenFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := openQueue(t, cfg)
+	if _, err := os.Stat(filepath.Join(cfg.Queue.Dir, legacySeenFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("legacy key file still exists after conversion: %v", err)
+	}
+	if err := q.Enqueue(&Job{ID: "recent"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Enqueue(recent) error = %v, want ErrDuplicate", err)
+	}
+	if err := q.Enqueue(&Job{ID: "expired"}); err != nil {
+		t.Errorf("Enqueue(expired): %v", err)
+	}
+}
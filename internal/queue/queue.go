@@ -0,0 +1,456 @@
+// Package queue provides a disk-backed job queue with step retries and a dead-letter list
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+)
+
+// ErrJobNotFound is returned when a dead-lettered job does not exist
+var ErrJobNotFound = errors.New("queue: job not found")
+
+// validID restricts job IDs to characters that are safe as file names
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Job is a unit of work, typically one webhook delivery
+type Job struct {
+	ID         string          `json:"id"`
+	Event      string          `json:"event"` // X-GitHub-Event
+	Payload    json.RawMessage `json:"payload"`
+	Keys       []string        `json:"keys,omitempty"`      // Idempotency keys besides the ID, e.g. repo#issue:action
+	Serial     string          `json:"serial,omitempty"`    // Jobs with the same Serial (e.g. repo#issue) run one at a time, in order
+	Completed  []string        `json:"completed,omitempty"` // Checkpointed steps from earlier attempts
+	EnqueuedAt time.Time       `json:"enqueued_at"`
+	FailedAt   time.Time       `json:"failed_at,omitempty"`
+	LastError  string          `json:"last_error,omitempty"`
+}
+
+// Done reports whether step was checkpointed by an earlier attempt of the job
+func (j *Job) Done(step string) bool {
+	for _, s := range j.Completed {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessFunc handles a job; a returned error moves the job to the dead-letter list
+type ProcessFunc func(ctx context.Context, job *Job) error
+
+// Queue persists jobs as JSON files under <dir>/pending until they are processed.
+// Jobs that fail are moved to <dir>/dead where they can be inspected and replayed.
+type Queue struct {
+	dir            string
+	workers        int
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	dedupTTL       time.Duration
+
+	mu            sync.Mutex
+	pending       []*Job
+	active        map[string]bool      // Serial keys of running jobs
+	seen          map[string]time.Time // Idempotency keys of accepted jobs
+	seenRecords   int                  // Records in the seen log, including outdated ones
+	seenCompacted time.Time            // Last rewrite of the seen log
+	notify        chan struct{}
+
+	stop     chan struct{} // Closed by Shutdown; workers finish their current job and exit
+	stopOnce sync.Once
+	cancel   context.CancelFunc // Interrupts running jobs when the shutdown deadline passes
+	running  sync.WaitGroup
+}
+
+// Open creates the queue directories and loads jobs left pending by a previous run
+func Open(cfg *config.Config) (*Queue, error) {
+	qc := cfg.Queue
+	q := &Queue{
+		dir:            qc.Dir,
+		workers:        qc.Workers,
+		maxAttempts:    qc.MaxAttempts,
+		initialBackoff: qc.InitialBackoff,
+		maxBackoff:     qc.MaxBackoff,
+		dedupTTL:       qc.DedupTTL,
+		active:         make(map[string]bool),
+		notify:         make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+	}
+	if q.dir == "" {
+		q.dir = "data/queue"
+	}
+	if q.workers <= 0 {
+		q.workers = 4
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = 5
+	}
+	if q.initialBackoff <= 0 {
+		q.initialBackoff = 2 * time.Second
+	}
+	if q.maxBackoff <= 0 {
+		q.maxBackoff = time.Minute
+	}
+	if q.dedupTTL <= 0 {
+		q.dedupTTL = 72 * time.Hour
+	}
+
+	for _, sub := range []string{"pending", "dead"} {
+		if err := os.MkdirAll(filepath.Join(q.dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("create queue directory: %w", err)
+		}
+	}
+	if err := q.loadSeen(); err != nil {
+		return nil, err
+	}
+	jobs, err := q.readDir("pending")
+	if err != nil {
+		return nil, err
+	}
+	q.pending = jobs
+	slog.Info("Job queue opened", "dir", q.dir, "pending", len(jobs), "workers", q.workers)
+	return q, nil
+}
+
+// Enqueue persists a job and schedules it for processing.
+// It returns ErrDuplicate without enqueuing if the job ID or one of its Keys was already accepted.
+func (q *Queue) Enqueue(job *Job) error {
+	if job.ID == "" {
+		job.ID = newID()
+	}
+	if !validID.MatchString(job.ID) {
+		return fmt.Errorf("queue: invalid job id %q", job.ID)
+	}
+	if job.EnqueuedAt.IsZero() {
+		job.EnqueuedAt = time.Now().UTC()
+	}
+	q.mu.Lock()
+	err := q.claim(job)
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := q.write("pending", job); err != nil {
+		q.release(job)
+		return err
+	}
+	q.push(job)
+	slog.Debug("Enqueued job", "delivery_id", job.ID, "event", job.Event)
+	return nil
+}
+
+// Start launches the worker pool; workers stop when ctx is cancelled or Shutdown is called
+func (q *Queue) Start(ctx context.Context, process ProcessFunc) {
+	ctx, q.cancel = context.WithCancel(ctx)
+	for i := 0; i < q.workers; i++ {
+		q.running.Add(1)
+		go func() {
+			defer q.running.Done()
+			q.work(ctx, process)
+		}()
+	}
+	q.signal()
+}
+
+// Shutdown stops taking new jobs and waits for running jobs to finish.
+// If ctx expires first, running jobs are interrupted and stay pending (with their
+// checkpoints) for the next run, and ctx's error is returned. Later calls only wait.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("Job queue drained")
+		return nil
+	case <-ctx.Done():
+		slog.Error("Shutdown deadline reached, interrupting running jobs")
+		if q.cancel != nil {
+			q.cancel()
+		}
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Checkpoint records that step of job completed, so a retried or replayed job skips it
+func (q *Queue) Checkpoint(job *Job, step string) error {
+	if job.Done(step) {
+		return nil
+	}
+	job.Completed = append(job.Completed, step)
+	return q.write("pending", job)
+}
+
+// Retry runs fn until it succeeds, returns a permanent error, or the attempts are exhausted.
+// The delay between attempts grows exponentially from queue.initial_backoff up to queue.max_backoff.
+func (q *Queue) Retry(ctx context.Context, step string, fn func(ctx context.Context) error) error {
+	delay := q.initialBackoff
+	var err error
+	for attempt := 1; attempt <= q.maxAttempts; attempt++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if attempt == q.maxAttempts {
+			break
+		}
+		slog.WarnContext(ctx, "Step failed, retrying", "step", step, "attempt", attempt, "max_attempts", q.maxAttempts, "retry_in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(delay*2, q.maxBackoff)
+	}
+	return fmt.Errorf("%s failed after %d attempts: %w", step, q.maxAttempts, err)
+}
+
+// DeadLetters returns the jobs that failed, oldest first
+func (q *Queue) DeadLetters() ([]*Job, error) {
+	return q.readDir("dead")
+}
+
+// Replay moves a dead-lettered job back to the pending queue
+func (q *Queue) Replay(id string) error {
+	if !validID.MatchString(id) {
+		return ErrJobNotFound
+	}
+	data, err := os.ReadFile(q.path("dead", id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return fmt.Errorf("decode job %s: %w", id, err)
+	}
+	job.FailedAt = time.Time{}
+	job.LastError = ""
+	q.mu.Lock()
+	err = q.accept(job.idempotencyKeys(), time.Now().UTC())
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := q.write("pending", &job); err != nil {
+		return err
+	}
+	if err := os.Remove(q.path("dead", id)); err != nil {
+		return err
+	}
+	q.push(&job)
+	slog.Info("Replaying dead-lettered job", "delivery_id", id)
+	return nil
+}
+
+// work processes jobs until ctx is cancelled
+func (q *Queue) work(ctx context.Context, process ProcessFunc) {
+	for {
+		job := q.next(ctx)
+		if job == nil {
+			return
+		}
+		err := q.run(ctx, job, process)
+		q.finish(job)
+		if err != nil {
+			// Shutting down: the job stays pending for the next run
+			return
+		}
+	}
+}
+
+// run processes one job, removing it from disk when it is done or dead-lettered.
+// It returns ctx's error if the job was interrupted.
+func (q *Queue) run(ctx context.Context, job *Job, process ProcessFunc) error {
+	slog.Debug("Processing job", "delivery_id", job.ID, "event", job.Event)
+	if err := process(ctx, job); err != nil {
+		if ctx.Err() != nil {
+			slog.Info("Job interrupted, keeping it pending", "delivery_id", job.ID)
+			return ctx.Err()
+		}
+		q.deadLetter(job, err)
+		return nil
+	}
+	if err := os.Remove(q.path("pending", job.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Failed to remove finished job", "delivery_id", job.ID, "error", err)
+	}
+	slog.Debug("Job completed", "delivery_id", job.ID)
+	return nil
+}
+
+// next blocks until a job is available, or returns nil once ctx is cancelled or the queue
+// is stopped. Jobs whose Serial key is held by a running job are skipped until it finishes.
+func (q *Queue) next(ctx context.Context) *Job {
+	for {
+		select {
+		case <-q.stop:
+			return nil
+		default:
+		}
+		q.mu.Lock()
+		if i := q.runnable(); i >= 0 {
+			job := q.pending[i]
+			q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+			if job.Serial != "" {
+				q.active[job.Serial] = true
+			}
+			more := q.runnable() >= 0
+			q.mu.Unlock()
+			if more {
+				q.signal()
+			}
+			return job
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-q.stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// runnable returns the index of the oldest pending job whose Serial key is free, or -1.
+// Callers hold q.mu.
+func (q *Queue) runnable() int {
+	for i, job := range q.pending {
+		if job.Serial == "" || !q.active[job.Serial] {
+			return i
+		}
+	}
+	return -1
+}
+
+// finish releases the Serial key of a job and wakes a worker for jobs held back by it
+func (q *Queue) finish(job *Job) {
+	if job.Serial == "" {
+		return
+	}
+	q.mu.Lock()
+	delete(q.active, job.Serial)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// push adds a job to the in-memory queue and wakes a worker
+func (q *Queue) push(job *Job) {
+	q.mu.Lock()
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// signal wakes one waiting worker without blocking
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// deadLetter moves a failed job to the dead-letter directory and releases its keys,
+// so that GitHub's own redelivery of the event is accepted again
+func (q *Queue) deadLetter(job *Job, cause error) {
+	slog.Error("Job failed, moving to dead-letter list", "delivery_id", job.ID, "error", cause)
+	job.FailedAt = time.Now().UTC()
+	job.LastError = cause.Error()
+	if err := q.write("dead", job); err != nil {
+		slog.Error("Failed to dead-letter job", "delivery_id", job.ID, "error", err)
+		return
+	}
+	if err := os.Remove(q.path("pending", job.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Failed to remove dead-lettered job", "delivery_id", job.ID, "error", err)
+	}
+	q.release(job)
+}
+
+// path returns the file of a job in a queue subdirectory
+func (q *Queue) path(sub, id string) string {
+	return filepath.Join(q.dir, sub, id+".json")
+}
+
+// write atomically stores a job in a queue subdirectory
+func (q *Queue) write(sub string, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("encode job %s: %w", job.ID, err)
+	}
+	tmp := q.path(sub, job.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write job %s: %w", job.ID, err)
+	}
+	if err := os.Rename(tmp, q.path(sub, job.ID)); err != nil {
+		return fmt.Errorf("persist job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// readDir loads all jobs in a queue subdirectory, oldest first
+func (q *Queue) readDir(sub string) ([]*Job, error) {
+	files, err := filepath.Glob(filepath.Join(q.dir, sub, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("read job %s: %w", f, err)
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			slog.Error("Skipping unreadable job file", "path", f, "error", err)
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].EnqueuedAt.Before(jobs[j].EnqueuedAt) })
+	return jobs, nil
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that Retry gives up immediately
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// newID generates a random job ID for jobs without a delivery ID
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+)
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Queue.Dir = t.TempDir()
+	cfg.Queue.MaxAttempts = 3
+	cfg.Queue.InitialBackoff = time.Millisecond
+	cfg.Queue.MaxBackoff = time.Millisecond
+	return cfg
+}
+
+func openQueue(t *testing.T, cfg *config.Config) *Queue {
+	t.Helper()
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return q
+}
+
+func TestRetry(t *testing.T) {
+	errTemporary := errors.New("temporary")
+	errFatal := errors.New("fatal")
+	tests := []struct {
+		name      string
+		failures  int   // Calls that fail before one succeeds
+		err       error // Error returned by the failing calls
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds at once", 0, errTemporary, 1, nil},
+		{"succeeds after retries", 2, errTemporary, 3, nil},
+		{"attempts exhausted", 5, errTemporary, 3, errTemporary},
+		{"permanent errors are not retried", 5, Permanent(errFatal), 1, errFatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := openQueue(t, testConfig(t))
+			calls := 0
+			err := q.Retry(context.Background(), "step", func(ctx context.Context) error {
+				calls++
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Retry error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFailedJobsAreDeadLettered(t *testing.T) {
+	q := openQueue(t, testConfig(t))
+	done := make(chan struct{}, 2)
+	q.Start(context.Background(), func(ctx context.Context, job *Job) error {
+		defer func() { done <- struct{}{} }()
+		if job.ID == "bad" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	for _, id := range []string{"good", "bad"} {
+		if err := q.Enqueue(&Job{ID: id}); err != nil {
+			t.Fatalf("Enqueue %s: %v", id, err)
+		}
+	}
+	<-done
+	<-done
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	dead, err := q.DeadLetters()
+	if err != nil {
+		t.Fatalf("DeadLetters: %v", err)
+	}
+	if len(dead) != 1 || dead[0].ID != "bad" || dead[0].LastError != "boom" {
+		t.Fatalf("DeadLetters = %+v, want only the failed job", dead)
+	}
+	if err := q.Replay("bad"); err != nil {
+		t.Errorf("Replay: %v", err)
+	}
+	if err := q.Replay("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Replay(missing) error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestSerialJobsRunInOrder(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Queue.Workers = 4
+	q := openQueue(t, cfg)
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	order := make(map[string][]string)
+	var wg sync.WaitGroup
+	jobs := []Job{
+		{ID: "a1", Serial: "o/r#1"}, {ID: "a2", Serial: "o/r#1"}, {ID: "b1", Serial: "o/r#2"},
+		{ID: "a3", Serial: "o/r#1"}, {ID: "b2", Serial: "o/r#2"}, {ID: "c1"},
+	}
+	wg.Add(len(jobs))
+	q.Start(context.Background(), func(ctx context.Context, job *Job) error {
+		defer wg.Done()
+		mu.Lock()
+		running[job.Serial]++
+		if job.Serial != "" && running[job.Serial] > 1 {
+			t.Errorf("job %s ran concurrently with another job of %s", job.ID, job.Serial)
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running[job.Serial]--
+		order[job.Serial] = append(order[job.Serial], job.ID)
+		mu.Unlock()
+		return nil
+	})
+	for i := range jobs {
+		if err := q.Enqueue(&jobs[i]); err != nil {
+			t.Fatalf("Enqueue %s: %v", jobs[i].ID, err)
+		}
+	}
+	wg.Wait()
+
+	want := map[string][]string{"o/r#1": {"a1", "a2", "a3"}, "o/r#2": {"b1", "b2"}}
+	for serial, ids := range want {
+		if got := order[serial]; !reflect.DeepEqual(got, ids) {
+			t.Errorf("order of %s = %v, want %v", serial, got, ids)
+		}
+	}
+	// Shutdown may be called more than once, e.g. by a signal and a deferred call
+	for i := 0; i < 2; i++ {
+		if err := q.Shutdown(context.Background()); err != nil {
+			t.Errorf("Shutdown #%d: %v", i+1, err)
+		}
+	}
+}
@@ -1,15 +1,19 @@
-// Package storage provides functionality to store and retrieve embeddings from BigQuery
+// Package storage provides functionality to store and retrieve issue embeddings (BigQuery by default)
 package storage
 
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
 	"github.com/google/go-github/v62/github"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/iterator"
 )
 
@@ -20,95 +24,354 @@ type BQClient struct {
 }
 
 // NewBQClient creates a new BigQuery client
-func NewBQClient(ctx context.Context, cfg *config.Config) *BQClient {
-	log.Printf("DEBUG: Initializing BigQuery client for project %s", cfg.GCP.ProjectID)
+func NewBQClient(ctx context.Context, cfg *config.Config) (*BQClient, error) {
+	slog.Debug("Initializing BigQuery client", "project", cfg.GCP.ProjectID)
 	cli, err := bigquery.NewClient(ctx, cfg.GCP.ProjectID)
 	if err != nil {
-		log.Fatalf("ERROR: BigQuery client initialization failed: %v", err)
+		return nil, fmt.Errorf("bigquery: create client: %w", err)
 	}
-	log.Printf("DEBUG: BigQuery client initialized successfully")
-	return &BQClient{client: cli, cfg: cfg}
+	b := &BQClient{client: cli, cfg: cfg}
+	if err := b.migrate(ctx); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	slog.Info("BigQuery client initialized", "project", cfg.GCP.ProjectID)
+	return b, nil
+}
+
+// bqColumns are the columns added to the issues table after its first release, with their types
+var bqColumns = []struct{ name, typ string }{
+	{"state", "STRING"},
+	{"state_reason", "STRING"},
+	{"chunk_index", "INT64"},
+	{"normalized_text", "STRING"},
 }
 
-// SearchSimilarIssues searches for similar issues based on vector distance
-func (b *BQClient) SearchSimilarIssues(ctx context.Context, vec []float64, topK int) ([]int64, []float64, error) {
-	log.Printf("DEBUG: Building BigQuery vector search query (topK=%d)", topK)
+// migrate adds the columns that tables created by earlier versions lack. The schema is read
+// first so that up-to-date tables need no permission to alter them.
+func (b *BQClient) migrate(ctx context.Context) error {
+	md, err := b.client.Dataset(b.cfg.GCP.BQDataset).Table(b.cfg.GCP.BQTable).Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("bigquery: read table schema: %w", err)
+	}
+	have := make(map[string]bool, len(md.Schema))
+	for _, f := range md.Schema {
+		have[strings.ToLower(f.Name)] = true
+	}
+	var missing, clauses []string
+	for _, col := range bqColumns {
+		if !have[col.name] {
+			missing = append(missing, col.name)
+			clauses = append(clauses, fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s %s", col.name, col.typ))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	slog.InfoContext(ctx, "Adding missing columns to the BigQuery table", "columns", missing)
+	q := b.client.Query(fmt.Sprintf("ALTER TABLE %s %s", b.tableRef(), strings.Join(clauses, ", ")))
+	if _, err := b.runDML(ctx, "migrate", q); err != nil {
+		return fmt.Errorf("bigquery: migrate: %w", err)
+	}
+	return nil
+}
 
-	// Using BigQuery vector search capabilities with correct syntax per docs
+// Ping checks that the issues table is reachable by reading its metadata
+func (b *BQClient) Ping(ctx context.Context) error {
+	_, err := b.client.Dataset(b.cfg.GCP.BQDataset).Table(b.cfg.GCP.BQTable).Metadata(ctx)
+	return err
+}
+
+// Close closes the underlying BigQuery client
+func (b *BQClient) Close() error {
+	slog.Debug("Closing BigQuery client")
+	return b.client.Close()
+}
+
+// SearchSimilarIssues searches for similar issues within opts based on vector distance,
+// with one VECTOR_SEARCH per query vector
+func (b *BQClient) SearchSimilarIssues(ctx context.Context, vecs [][]float64, opts SearchOptions) ([]SimilarIssue, error) {
+	return searchChunks(ctx, vecs, opts, func(ctx context.Context, vec []float64, limit int) ([]SimilarIssue, error) {
+		return b.searchChunk(ctx, vec, limit, opts)
+	})
+}
+
+// searchChunk returns the topK chunk rows nearest to vec within opts
+func (b *BQClient) searchChunk(ctx context.Context, vec []float64, topK int, opts SearchOptions) (_ []SimilarIssue, err error) {
+	slog.DebugContext(ctx, "Running BigQuery vector search", "top_k", topK, "repos", opts.Repos, "open_only", opts.OpenOnly, "distance", b.cfg.GCP.VectorSearch.Distance, "dimensions", len(vec))
+	ctx, span := b.startSpan(ctx, "vector_search")
+	defer func() { tracing.End(span, err) }()
+
+	// VECTOR_SEARCH over the in-scope rows; for DOT_PRODUCT the returned distance is the negated dot product
+	// Rows stored before state tracking have a NULL state and count as open
+	metric := ParseMetric(b.cfg.GCP.VectorSearch.Distance)
+	filter := "LOWER(repo) IN UNNEST(@repos)"
+	if opts.OpenOnly {
+		filter += " AND IFNULL(state, 'open') = 'open'"
+	}
 	q := b.client.Query(fmt.Sprintf(`
-        SELECT issue_id, 
-        VECTOR_SEARCH_DISTANCE(embedding, @query_vec) AS dist
-        FROM `+"`%s.%s.%s`"+`
-        WHERE VECTOR_SEARCH_COSINE_DISTANCE(embedding, @query_vec) <= 2.0
-        ORDER BY dist
-        LIMIT %d`,
-		b.cfg.GCP.ProjectID, b.cfg.GCP.BQDataset, b.cfg.GCP.BQTable, topK))
-
-	log.Printf("DEBUG: Using query parameters with vector of %d dimensions", len(vec))
-	q.Parameters = []bigquery.QueryParameter{{Name: "query_vec", Value: vec}}
-
-	log.Printf("DEBUG: Executing BigQuery vector search")
+        SELECT base.repo AS repo, base.issue_id AS issue_id, distance AS dist,
+          IFNULL(base.state, 'open') AS state, IFNULL(base.state_reason, '') AS state_reason
+        FROM VECTOR_SEARCH(
+          (SELECT * FROM %s WHERE %s),
+          'embedding',
+          (SELECT @query_vec AS embedding),
+          top_k => %d,
+          distance_type => '%s')
+        ORDER BY dist`,
+		b.tableRef(), filter, topK, metric))
+
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "query_vec", Value: vec},
+		{Name: "repos", Value: lowerRepos(opts.Repos)},
+	}
+
 	it, err := q.Read(ctx)
 	if err != nil {
-		log.Printf("ERROR: BigQuery query execution failed: %v", err)
-		return nil, nil, err
+		slog.ErrorContext(ctx, "BigQuery query execution failed", "error", err)
+		return nil, err
 	}
 
-	var ids []int64
-	var dists []float64
-	rowCount := 0
-	log.Printf("DEBUG: Processing BigQuery query results")
+	var results []SimilarIssue
 
 	for {
-		var row struct {
-			IssueID int64   `bigquery:"issue_id"`
-			Dist    float64 `bigquery:"dist"`
-		}
+		var row SimilarIssue
 		switch err := it.Next(&row); err {
 		case iterator.Done:
-			log.Printf("DEBUG: Completed reading %d similar issues from BigQuery", rowCount)
-			return ids, dists, nil
+			slog.DebugContext(ctx, "Read similar issues from BigQuery", "count", len(results))
+			return results, nil
 		case nil:
-			ids = append(ids, row.IssueID)
-			dists = append(dists, row.Dist)
-			rowCount++
+			results = append(results, row)
 		default:
-			log.Printf("ERROR: Error reading BigQuery results: %v", err)
-			return nil, nil, err
+			slog.ErrorContext(ctx, "Error reading BigQuery results", "error", err)
+			return nil, err
 		}
 	}
 }
 
-// IssueRow represents a BigQuery row for issue data
+// IssueRow represents a BigQuery row for issue data: one chunk of an issue and its embedding.
+// Body is only stored with chunk 0.
 type IssueRow struct {
 	Repo      string    `bigquery:"repo"`
 	IssueID   int64     `bigquery:"issue_id"`
+	Chunk     int64     `bigquery:"chunk_index"` // Rows stored before chunking have NULL, read as 0
 	Title     string    `bigquery:"title"`
 	Body      string    `bigquery:"body"`
 	CreatedAt time.Time `bigquery:"created_at"`
 	Embedding []float64 `bigquery:"embedding"`
+	// NormalizedText is the preprocessed chunk text the embedding was created from
+	NormalizedText string `bigquery:"normalized_text"`
+	// State is "open" or "closed"; StateReason is GitHub's state_reason (e.g. not_planned, duplicate)
+	State       string `bigquery:"state"`
+	StateReason string `bigquery:"state_reason"`
+}
+
+// chunkParam is one element of the @chunks array parameter
+type chunkParam struct {
+	Text      string    `bigquery:"text"`
+	Embedding []float64 `bigquery:"embedding"`
 }
 
-// InsertIssueVector stores issue data and its embedding vector into BigQuery
-func (b *BQClient) InsertIssueVector(ctx context.Context, issue *github.Issue, repo string, vec []float64) error {
-	log.Printf("DEBUG: Preparing to insert issue data into BigQuery table %s.%s", b.cfg.GCP.BQDataset, b.cfg.GCP.BQTable)
-	ins := b.client.Dataset(b.cfg.GCP.BQDataset).Table(b.cfg.GCP.BQTable).Inserter()
-	log.Printf("DEBUG: Creating issue row for repo=%s, issue_id=%d, title=%q",
-		repo, issue.GetNumber(), issue.GetTitle())
-	row := &IssueRow{
-		Repo:      repo,
-		IssueID:   int64(issue.GetNumber()),
-		Title:     issue.GetTitle(),
-		Body:      issue.GetBody(),
-		CreatedAt: issue.GetCreatedAt().Time,
-		Embedding: vec,
-	}
-	log.Printf("DEBUG: Inserting row into BigQuery with embedding vector of length %d", len(vec))
-	err := ins.Put(ctx, row)
+// InsertIssueVector stores issue data into BigQuery with one row per chunk.
+// Existing rows are deleted and the new ones inserted in one transaction, so that a
+// redelivered webhook does not create duplicate rows.
+func (b *BQClient) InsertIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error {
+	slog.DebugContext(ctx, "Upserting issue vectors into BigQuery", "table", b.cfg.GCP.BQDataset+"."+b.cfg.GCP.BQTable, "repo", repo, "issue", issue.GetNumber(), "chunks", len(chunks))
+	err := b.replaceChunks(ctx, "upsert", newIssueRow(issue, repo, nil), chunks)
 	if err != nil {
-		log.Printf("ERROR: BigQuery insertion failed: %v", err)
+		slog.ErrorContext(ctx, "BigQuery upsert failed", "repo", repo, "issue", issue.GetNumber(), "error", err)
 	} else {
-		log.Printf("DEBUG: BigQuery insertion successful for issue #%d", issue.GetNumber())
+		slog.DebugContext(ctx, "BigQuery upsert successful", "repo", repo, "issue", issue.GetNumber())
+	}
+	return err
+}
+
+// replaceChunks replaces all rows of row's issue with one row per chunk, taking the
+// other columns from row
+func (b *BQClient) replaceChunks(ctx context.Context, op string, row *IssueRow, chunks []Chunk) error {
+	params := make([]chunkParam, len(chunks))
+	for i, c := range chunks {
+		params[i] = chunkParam{Text: c.Text, Embedding: c.Embedding}
 	}
+	q := b.client.Query(fmt.Sprintf(`
+        BEGIN TRANSACTION;
+        DELETE FROM %[1]s WHERE LOWER(repo) = LOWER(@repo) AND issue_id = @issue_id;
+        INSERT INTO %[1]s (repo, issue_id, chunk_index, title, body, created_at, embedding, normalized_text, state, state_reason)
+        SELECT @repo, @issue_id, chunk_index, @title, IF(chunk_index = 0, @body, ''), @created_at,
+          c.embedding, c.text, @state, @state_reason
+        FROM UNNEST(@chunks) AS c WITH OFFSET AS chunk_index;
+        COMMIT TRANSACTION;`, b.tableRef()))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "repo", Value: row.Repo},
+		{Name: "issue_id", Value: row.IssueID},
+		{Name: "title", Value: row.Title},
+		{Name: "body", Value: row.Body},
+		{Name: "created_at", Value: row.CreatedAt},
+		{Name: "chunks", Value: params},
+		{Name: "state", Value: row.State},
+		{Name: "state_reason", Value: row.StateReason},
+	}
+	_, err := b.runDML(ctx, op, q)
 	return err
 }
+
+// tableRef returns the fully qualified table name for use in SQL
+func (b *BQClient) tableRef() string {
+	return fmt.Sprintf("`%s.%s.%s`", b.cfg.GCP.ProjectID, b.cfg.GCP.BQDataset, b.cfg.GCP.BQTable)
+}
+
+// runDML executes a DML statement, waits for it to complete and returns the number of
+// rows it changed (0 for multi-statement scripts)
+func (b *BQClient) runDML(ctx context.Context, op string, q *bigquery.Query) (_ int64, err error) {
+	ctx, span := b.startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return 0, err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := status.Err(); err != nil {
+		return 0, err
+	}
+	if status.Statistics != nil {
+		if qs, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+			return qs.NumDMLAffectedRows, nil
+		}
+	}
+	return 0, nil
+}
+
+// startSpan starts a client span for a BigQuery operation on the issues table
+func (b *BQClient) startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "bigquery."+op,
+		attribute.String("db.system", "bigquery"),
+		attribute.String("db.name", b.cfg.GCP.BQDataset+"."+b.cfg.GCP.BQTable),
+		attribute.String("db.operation", op),
+	)
+}
+
+// UpdateIssueVector replaces the stored title, body and chunks of an issue,
+// keeping its creation time and state
+func (b *BQClient) UpdateIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error {
+	slog.DebugContext(ctx, "Updating issue vectors in BigQuery", "repo", repo, "issue", issue.GetNumber(), "chunks", len(chunks))
+	prev, err := b.GetIssueVector(ctx, repo, int64(issue.GetNumber()))
+	if err != nil {
+		return err
+	}
+	row := newIssueRow(issue, repo, nil)
+	row.CreatedAt, row.State, row.StateReason = prev.CreatedAt, prev.State, prev.StateReason
+	if err := b.replaceChunks(ctx, "update", row, chunks); err != nil {
+		slog.ErrorContext(ctx, "BigQuery update failed", "repo", repo, "issue", issue.GetNumber(), "error", err)
+		return err
+	}
+	slog.DebugContext(ctx, "BigQuery update successful", "repo", repo, "issue", issue.GetNumber())
+	return nil
+}
+
+// DeleteIssueVector removes the stored vectors of an issue, or returns ErrNotFound
+func (b *BQClient) DeleteIssueVector(ctx context.Context, repo string, issueID int64) error {
+	slog.DebugContext(ctx, "Deleting issue vector from BigQuery", "repo", repo, "issue", issueID)
+	q := b.client.Query(fmt.Sprintf(`
+        DELETE FROM %s
+        WHERE LOWER(repo) = LOWER(@repo) AND issue_id = @issue_id`, b.tableRef()))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "repo", Value: repo},
+		{Name: "issue_id", Value: issueID},
+	}
+	n, err := b.runDML(ctx, "delete", q)
+	if err != nil {
+		slog.ErrorContext(ctx, "BigQuery delete failed", "repo", repo, "issue", issueID, "error", err)
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	slog.DebugContext(ctx, "BigQuery delete successful", "repo", repo, "issue", issueID)
+	return nil
+}
+
+// GetIssueVector returns the stored row of an issue's first chunk, or ErrNotFound
+func (b *BQClient) GetIssueVector(ctx context.Context, repo string, issueID int64) (_ *IssueRow, err error) {
+	slog.DebugContext(ctx, "Fetching issue vector from BigQuery", "repo", repo, "issue", issueID)
+	ctx, span := b.startSpan(ctx, "get")
+	defer func() { tracing.End(span, err) }()
+	q := b.client.Query(fmt.Sprintf(`
+        SELECT repo, issue_id, IFNULL(chunk_index, 0) AS chunk_index, title, body, created_at, embedding,
+          IFNULL(normalized_text, '') AS normalized_text,
+          IFNULL(state, 'open') AS state, IFNULL(state_reason, '') AS state_reason
+        FROM %s
+        WHERE LOWER(repo) = LOWER(@repo) AND issue_id = @issue_id
+        ORDER BY chunk_index
+        LIMIT 1`, b.tableRef()))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "repo", Value: repo},
+		{Name: "issue_id", Value: issueID},
+	}
+	it, err := q.Read(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "BigQuery query execution failed", "repo", repo, "issue", issueID, "error", err)
+		return nil, err
+	}
+	var row IssueRow
+	switch err := it.Next(&row); err {
+	case iterator.Done:
+		return nil, ErrNotFound
+	case nil:
+		return &row, nil
+	default:
+		slog.ErrorContext(ctx, "Error reading BigQuery results", "repo", repo, "issue", issueID, "error", err)
+		return nil, err
+	}
+}
+
+// SetIssueState records that an issue was closed (with a reason) or reopened
+func (b *BQClient) SetIssueState(ctx context.Context, repo string, issueID int64, state, reason string) error {
+	slog.DebugContext(ctx, "Setting issue state in BigQuery", "repo", repo, "issue", issueID, "state", state, "state_reason", reason)
+	q := b.client.Query(fmt.Sprintf(`
+        UPDATE %s
+        SET state = @state, state_reason = @state_reason
+        WHERE LOWER(repo) = LOWER(@repo) AND issue_id = @issue_id`, b.tableRef()))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "state", Value: state},
+		{Name: "state_reason", Value: reason},
+		{Name: "repo", Value: repo},
+		{Name: "issue_id", Value: issueID},
+	}
+	n, err := b.runDML(ctx, "set_state", q)
+	if err != nil {
+		slog.ErrorContext(ctx, "BigQuery state update failed", "repo", repo, "issue", issueID, "error", err)
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TransferIssueVector moves the stored vectors of an issue to its new repository and number
+func (b *BQClient) TransferIssueVector(ctx context.Context, fromRepo string, fromID int64, toRepo string, toID int64) error {
+	slog.DebugContext(ctx, "Transferring issue vector in BigQuery", "from", fmt.Sprintf("%s#%d", fromRepo, fromID), "to", fmt.Sprintf("%s#%d", toRepo, toID))
+	q := b.client.Query(fmt.Sprintf(`
+        UPDATE %s
+        SET repo = @to_repo, issue_id = @to_id
+        WHERE LOWER(repo) = LOWER(@from_repo) AND issue_id = @from_id`, b.tableRef()))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "to_repo", Value: toRepo},
+		{Name: "to_id", Value: toID},
+		{Name: "from_repo", Value: fromRepo},
+		{Name: "from_id", Value: fromID},
+	}
+	n, err := b.runDML(ctx, "transfer", q)
+	if err != nil {
+		slog.ErrorContext(ctx, "BigQuery transfer failed", "from", fmt.Sprintf("%s#%d", fromRepo, fromID), "error", err)
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
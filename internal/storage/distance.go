@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"math"
+	"strings"
+)
+
+// Metric is a vector distance metric as configured in GCP.VectorSearch.Distance.
+// Every VectorStore returns distances where smaller means more similar;
+// for DOT_PRODUCT that distance is the negated dot product, matching BigQuery's VECTOR_SEARCH.
+type Metric string
+
+// Supported distance metrics
+const (
+	MetricCosine     Metric = "COSINE"
+	MetricDotProduct Metric = "DOT_PRODUCT"
+	MetricEuclidean  Metric = "EUCLIDEAN"
+)
+
+// ParseMetric normalizes a configured metric name, defaulting to cosine
+func ParseMetric(name string) Metric {
+	switch m := Metric(strings.ToUpper(strings.TrimSpace(name))); m {
+	case MetricDotProduct, MetricEuclidean:
+		return m
+	default:
+		return MetricCosine
+	}
+}
+
+// Score converts a store distance into the value shown to users and compared
+// against the similarity threshold: the distance itself for COSINE and EUCLIDEAN,
+// and the (sign-flipped) dot product for DOT_PRODUCT
+func (m Metric) Score(distance float64) float64 {
+	if m == MetricDotProduct {
+		return -distance
+	}
+	return distance
+}
+
+// distanceFunc returns the distance function for the metric
+func (m Metric) distanceFunc() func(a, b []float64) float64 {
+	switch m {
+	case MetricDotProduct:
+		return negDotProduct
+	case MetricEuclidean:
+		return euclideanDistance
+	default:
+		return cosineDistance
+	}
+}
+
+// cosineDistance returns 1 - cosine similarity, in the range [0, 2]
+func cosineDistance(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(na)*math.Sqrt(nb))
+}
+
+// negDotProduct returns the negated dot product
+func negDotProduct(a, b []float64) float64 {
+	var dot float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += a[i] * b[i]
+	}
+	return -dot
+}
+
+// euclideanDistance returns the L2 distance
+func euclideanDistance(a, b []float64) float64 {
+	var sum float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
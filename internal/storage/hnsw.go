@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// hnswCandidate is a node ID paired with its distance to the query
+type hnswCandidate struct {
+	id   int
+	dist float64
+}
+
+// hnswIndex is a minimal in-memory Hierarchical Navigable Small World graph
+// for approximate nearest neighbor search. Node IDs are insertion indexes.
+type hnswIndex struct {
+	m              int
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+	dist           func(a, b []float64) float64
+	rng            *rand.Rand
+
+	vecs     [][]float64
+	links    [][][]int // node -> layer -> neighbor IDs
+	entry    int
+	maxLevel int
+}
+
+// newHNSWIndex creates an empty index, applying defaults for unset parameters
+func newHNSWIndex(m, efConstruction, efSearch int, dist func(a, b []float64) float64) *hnswIndex {
+	if m <= 1 {
+		m = 16
+	}
+	if efConstruction <= 0 {
+		efConstruction = 200
+	}
+	if efSearch <= 0 {
+		efSearch = 64
+	}
+	return &hnswIndex{
+		m:              m,
+		efConstruction: efConstruction,
+		efSearch:       efSearch,
+		levelMult:      1 / math.Log(float64(m)),
+		dist:           dist,
+		rng:            rand.New(rand.NewSource(1)),
+		entry:          -1,
+	}
+}
+
+// add inserts a vector and returns its node ID
+func (h *hnswIndex) add(vec []float64) int {
+	id := len(h.vecs)
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	h.vecs = append(h.vecs, vec)
+	h.links = append(h.links, make([][]int, level+1))
+
+	if h.entry < 0 {
+		h.entry = id
+		h.maxLevel = level
+		return id
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.searchLayer(vec, ep, 1, l)[0].id
+	}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		cands := h.searchLayer(vec, ep, h.efConstruction, l)
+		maxConn := h.m
+		if l == 0 {
+			maxConn = 2 * h.m
+		}
+		for _, c := range cands[:min(len(cands), h.m)] {
+			h.links[id][l] = append(h.links[id][l], c.id)
+			h.links[c.id][l] = append(h.links[c.id][l], id)
+			if len(h.links[c.id][l]) > maxConn {
+				h.prune(c.id, l, maxConn)
+			}
+		}
+		ep = cands[0].id
+	}
+	if level > h.maxLevel {
+		h.maxLevel = level
+		h.entry = id
+	}
+	return id
+}
+
+// search returns up to k nearest nodes ordered by ascending distance
+func (h *hnswIndex) search(query []float64, k int) []hnswCandidate {
+	if h.entry < 0 || k <= 0 {
+		return nil
+	}
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.searchLayer(query, ep, 1, l)[0].id
+	}
+	res := h.searchLayer(query, ep, max(h.efSearch, k), 0)
+	if len(res) > k {
+		res = res[:k]
+	}
+	return res
+}
+
+// searchLayer performs a best-first search on one layer and returns up to ef
+// candidates ordered by ascending distance
+func (h *hnswIndex) searchLayer(query []float64, ep, ef, level int) []hnswCandidate {
+	visited := map[int]bool{ep: true}
+	start := hnswCandidate{id: ep, dist: h.dist(query, h.vecs[ep])}
+	queue := []hnswCandidate{start}
+	results := []hnswCandidate{start}
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if len(results) >= ef && c.dist > results[len(results)-1].dist {
+			break
+		}
+		for _, n := range h.links[c.id][level] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			d := h.dist(query, h.vecs[n])
+			if len(results) < ef || d < results[len(results)-1].dist {
+				queue = insertCandidate(queue, hnswCandidate{id: n, dist: d})
+				results = insertCandidate(results, hnswCandidate{id: n, dist: d})
+				if len(results) > ef {
+					results = results[:ef]
+				}
+			}
+		}
+	}
+	return results
+}
+
+// prune keeps only the maxConn closest neighbors of a node on a layer
+func (h *hnswIndex) prune(id, level, maxConn int) {
+	neighbors := h.links[id][level]
+	sort.Slice(neighbors, func(i, j int) bool {
+		return h.dist(h.vecs[id], h.vecs[neighbors[i]]) < h.dist(h.vecs[id], h.vecs[neighbors[j]])
+	})
+	h.links[id][level] = neighbors[:maxConn]
+}
+
+// insertCandidate inserts c into a slice kept sorted by ascending distance
+func insertCandidate(s []hnswCandidate, c hnswCandidate) []hnswCandidate {
+	i := sort.Search(len(s), func(i int) bool { return s[i].dist > c.dist })
+	s = append(s, hnswCandidate{})
+	copy(s[i+1:], s[i:])
+	s[i] = c
+	return s
+}
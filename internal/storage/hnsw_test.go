@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// randomVectors returns n vectors of dim components drawn from a fixed seed
+func randomVectors(rng *rand.Rand, n, dim int) [][]float64 {
+	vecs := make([][]float64, n)
+	for i := range vecs {
+		vecs[i] = make([]float64, dim)
+		for j := range vecs[i] {
+			vecs[i][j] = rng.NormFloat64()
+		}
+	}
+	return vecs
+}
+
+func TestHNSWRecall(t *testing.T) {
+	const (
+		n       = 2000
+		dim     = 16
+		queries = 100
+		k       = 10
+	)
+	for _, metric := range []Metric{MetricCosine, MetricEuclidean} {
+		t.Run(string(metric), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(42))
+			dist := metric.distanceFunc()
+			h := newHNSWIndex(0, 0, 0, dist)
+			vecs := randomVectors(rng, n, dim)
+			for _, v := range vecs {
+				h.add(v)
+			}
+
+			found := 0
+			for _, q := range randomVectors(rng, queries, dim) {
+				// Exact neighbors by brute force
+				ids := make([]int, n)
+				for i := range ids {
+					ids[i] = i
+				}
+				sort.Slice(ids, func(i, j int) bool { return dist(q, vecs[ids[i]]) < dist(q, vecs[ids[j]]) })
+				want := make(map[int]bool, k)
+				for _, id := range ids[:k] {
+					want[id] = true
+				}
+				for _, c := range h.search(q, k) {
+					if want[c.id] {
+						found++
+					}
+				}
+			}
+			if recall := float64(found) / (queries * k); recall < 0.95 {
+				t.Errorf("recall@%d = %.3f, want at least 0.95", k, recall)
+			}
+		})
+	}
+}
+
+func TestHNSWSearchFallsBackToBruteForce(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestLocalStore(t, "hnsw")
+	rng := rand.New(rand.NewSource(7))
+
+	// Many issues next to the query in one repository crowd the few of another
+	// out of the index's candidates
+	for i, v := range randomVectors(rng, 300, 8) {
+		v[0] += 50
+		if err := s.InsertIssueVector(ctx, testIssue(i+1, "near"), "owner/busy", []Chunk{{Embedding: v}}); err != nil {
+			t.Fatalf("InsertIssueVector: %v", err)
+		}
+	}
+	var want []string
+	for i, v := range randomVectors(rng, 3, 8) {
+		v[0] -= 50
+		if err := s.InsertIssueVector(ctx, testIssue(i+1, "far"), "owner/quiet", []Chunk{{Embedding: v}}); err != nil {
+			t.Fatalf("InsertIssueVector: %v", err)
+		}
+		want = append(want, fmt.Sprintf("owner/quiet#%d", i+1))
+	}
+
+	query := make([]float64, 8)
+	query[0] = 1
+	var got []string
+	for _, hit := range search(t, s, query, SearchOptions{Repos: []string{"owner/quiet"}, TopK: 3}) {
+		got = append(got, fmt.Sprintf("%s#%d", hit.Repo, hit.IssueID))
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("search = %v, want all of %v", got, want)
+	}
+}
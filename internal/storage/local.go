@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/google/go-github/v62/github"
+)
+
+// LocalStore is a self-contained, file-backed vector store.
+// All rows are kept in memory and persisted to a single gob file on every change;
+// searches are answered in-process by brute force or, optionally, an HNSW index.
+type LocalStore struct {
+	mu   sync.RWMutex
+	path string
+	cfg  *config.Config
+	dist func(a, b []float64) float64
+
+	rows       []*IssueRow
+	index      *hnswIndex // nil when brute-force search is used
+	indexDirty bool
+}
+
+// Ensure LocalStore satisfies VectorStore
+var _ VectorStore = (*LocalStore)(nil)
+
+// NewLocalStore opens (or creates) the local store configured in cfg.Storage.Local
+func NewLocalStore(cfg *config.Config) (*LocalStore, error) {
+	path := cfg.Storage.Local.Path
+	if path == "" {
+		path = "data/issues_vectors.gob"
+	}
+	slog.Debug("Initializing local vector store", "path", path)
+
+	s := &LocalStore{
+		path: path,
+		cfg:  cfg,
+		dist: ParseMetric(cfg.GCP.VectorSearch.Distance).distanceFunc(),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(cfg.Storage.Local.Index, "hnsw") {
+		s.indexDirty = true
+		s.rebuildIndex()
+	}
+	slog.Info("Local vector store loaded", "rows", len(s.rows), "index", s.indexName())
+	return s, nil
+}
+
+// SearchSimilarIssues searches for similar issues within opts based on vector distance
+func (s *LocalStore) SearchSimilarIssues(ctx context.Context, vecs [][]float64, opts SearchOptions) ([]SimilarIssue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "Searching local vector store", "top_k", opts.TopK, "queries", len(vecs), "rows", len(s.rows), "index", s.indexName(), "repos", opts.Repos, "open_only", opts.OpenOnly)
+
+	inScope := make(map[string]bool, len(opts.Repos))
+	for _, r := range opts.Repos {
+		inScope[strings.ToLower(r)] = true
+	}
+	matches := func(row *IssueRow) bool {
+		if opts.OpenOnly && row.State == StateClosed {
+			return false
+		}
+		return inScope[strings.ToLower(row.Repo)]
+	}
+
+	results, err := searchChunks(ctx, vecs, opts, func(ctx context.Context, vec []float64, limit int) ([]SimilarIssue, error) {
+		return s.searchChunk(vec, limit, matches), nil
+	})
+	slog.DebugContext(ctx, "Read similar issues from local store", "count", len(results))
+	return results, err
+}
+
+// searchChunk returns the topK chunk rows nearest to vec among those matching the search scope.
+// The caller must hold s.mu.
+func (s *LocalStore) searchChunk(vec []float64, topK int, matches func(*IssueRow) bool) []SimilarIssue {
+	var hits []hnswCandidate
+	if s.index != nil {
+		s.rebuildIndex()
+		// Over-fetch so that filtering by repo still leaves topK hits in most cases
+		for _, h := range s.index.search(vec, max(topK*10, s.index.efSearch)) {
+			if matches(s.rows[h.id]) && len(hits) < topK {
+				hits = append(hits, h)
+			}
+		}
+	}
+	if len(hits) < topK {
+		// Brute force over the rows in scope
+		hits = hits[:0]
+		for i, row := range s.rows {
+			if matches(row) {
+				hits = append(hits, hnswCandidate{id: i, dist: s.dist(vec, row.Embedding)})
+			}
+		}
+		sort.Slice(hits, func(i, j int) bool { return hits[i].dist < hits[j].dist })
+		if len(hits) > topK {
+			hits = hits[:topK]
+		}
+	}
+
+	results := make([]SimilarIssue, 0, len(hits))
+	for _, h := range hits {
+		row := s.rows[h.id]
+		results = append(results, SimilarIssue{
+			Repo:        row.Repo,
+			IssueID:     row.IssueID,
+			Distance:    h.dist,
+			State:       row.stateOrOpen(),
+			StateReason: row.StateReason,
+		})
+	}
+	return results
+}
+
+// InsertIssueVector stores issue data with one row per chunk.
+// Existing rows for the same repo and issue are replaced.
+func (s *LocalStore) InsertIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "Inserting issue vectors into local store", "repo", repo, "issue", issue.GetNumber(), "chunks", len(chunks))
+
+	s.remove(repo, int64(issue.GetNumber()))
+	s.add(newChunkRows(issue, repo, chunks))
+	return s.save()
+}
+
+// UpdateIssueVector replaces the stored title, body and chunks of an issue
+func (s *LocalStore) UpdateIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "Updating issue vectors in local store", "repo", repo, "issue", issue.GetNumber(), "chunks", len(chunks))
+
+	i := s.find(repo, int64(issue.GetNumber()))
+	if i < 0 {
+		return ErrNotFound
+	}
+	prev := s.rows[i]
+	rows := newChunkRows(issue, repo, chunks)
+	for _, row := range rows {
+		row.CreatedAt, row.State, row.StateReason = prev.CreatedAt, prev.State, prev.StateReason
+	}
+	s.remove(repo, prev.IssueID)
+	s.add(rows)
+	return s.save()
+}
+
+// DeleteIssueVector removes the stored vectors of an issue, or returns ErrNotFound
+func (s *LocalStore) DeleteIssueVector(ctx context.Context, repo string, issueID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "Deleting issue vectors from local store", "repo", repo, "issue", issueID)
+
+	if !s.remove(repo, issueID) {
+		return ErrNotFound
+	}
+	return s.save()
+}
+
+// GetIssueVector returns the stored row of an issue's first chunk, or ErrNotFound
+func (s *LocalStore) GetIssueVector(ctx context.Context, repo string, issueID int64) (*IssueRow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.find(repo, issueID)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	row := *s.rows[i]
+	return &row, nil
+}
+
+// SetIssueState records that an issue was closed (with a reason) or reopened
+func (s *LocalStore) SetIssueState(ctx context.Context, repo string, issueID int64, state, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "Setting issue state in local store", "repo", repo, "issue", issueID, "state", state, "state_reason", reason)
+
+	found := false
+	for _, row := range s.rows {
+		if sameIssue(row, repo, issueID) {
+			row.State, row.StateReason, found = state, reason, true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return s.save()
+}
+
+// TransferIssueVector moves the stored vector of an issue to its new repository and number,
+// replacing any rows already stored there
+func (s *LocalStore) TransferIssueVector(ctx context.Context, fromRepo string, fromID int64, toRepo string, toID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "Transferring issue vector in local store", "from", fmt.Sprintf("%s#%d", fromRepo, fromID), "to", fmt.Sprintf("%s#%d", toRepo, toID))
+
+	if s.find(fromRepo, fromID) < 0 {
+		return ErrNotFound
+	}
+	if fromID != toID || !strings.EqualFold(fromRepo, toRepo) {
+		s.remove(toRepo, toID)
+	}
+	for _, row := range s.rows {
+		if sameIssue(row, fromRepo, fromID) {
+			row.Repo, row.IssueID = toRepo, toID
+		}
+	}
+	return s.save()
+}
+
+// Ping always succeeds: the store lives in process memory
+func (s *LocalStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op: every change is already persisted to the data file
+func (s *LocalStore) Close() error {
+	return nil
+}
+
+// find returns the index of the first chunk row for repo and issueID, or -1
+func (s *LocalStore) find(repo string, issueID int64) int {
+	first := -1
+	for i, row := range s.rows {
+		if sameIssue(row, repo, issueID) && (first < 0 || row.Chunk < s.rows[first].Chunk) {
+			first = i
+		}
+	}
+	return first
+}
+
+// sameIssue reports whether row belongs to the issue; repository names are compared
+// case-insensitively, as in searches
+func sameIssue(row *IssueRow, repo string, issueID int64) bool {
+	return row.IssueID == issueID && strings.EqualFold(row.Repo, repo)
+}
+
+// remove deletes all chunk rows of an issue and reports whether there were any
+func (s *LocalStore) remove(repo string, issueID int64) bool {
+	kept := s.rows[:0]
+	for _, row := range s.rows {
+		if !sameIssue(row, repo, issueID) {
+			kept = append(kept, row)
+		}
+	}
+	removed := len(kept) < len(s.rows)
+	clear(s.rows[len(kept):])
+	s.rows = kept
+	if removed {
+		s.indexDirty = true
+	}
+	return removed
+}
+
+// add appends rows, adding them to the HNSW index unless it is rebuilt anyway
+func (s *LocalStore) add(rows []*IssueRow) {
+	for _, row := range rows {
+		s.rows = append(s.rows, row)
+		if s.index != nil && !s.indexDirty {
+			s.index.add(row.Embedding)
+		}
+	}
+}
+
+// indexName describes the search strategy for log output
+func (s *LocalStore) indexName() string {
+	if s.index != nil {
+		return "hnsw"
+	}
+	return "flat"
+}
+
+// rebuildIndex recreates the HNSW graph after updates or deletes invalidated it
+func (s *LocalStore) rebuildIndex() {
+	if !s.indexDirty {
+		return
+	}
+	p := s.cfg.Storage.Local.HNSW
+	s.index = newHNSWIndex(p.M, p.EfConstruction, p.EfSearch, s.dist)
+	for _, row := range s.rows {
+		s.index.add(row.Embedding)
+	}
+	s.indexDirty = false
+	slog.Debug("Rebuilt HNSW index", "nodes", len(s.rows))
+}
+
+// load reads all rows from the data file; a missing file means an empty store
+func (s *LocalStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open local store: %w", err)
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(&s.rows); err != nil {
+		return fmt.Errorf("decode local store: %w", err)
+	}
+	return nil
+}
+
+// save atomically rewrites the data file with the current rows
+func (s *LocalStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create local store directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create local store temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(s.rows); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encode local store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync local store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close local store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		slog.Error("Failed to persist local store", "path", s.path, "error", err)
+		return fmt.Errorf("persist local store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/google/go-github/v62/github"
+)
+
+func newTestLocalStore(t *testing.T, index string) (*LocalStore, *config.Config) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.GCP.VectorSearch.Distance = "COSINE"
+	cfg.Storage.Local.Path = filepath.Join(t.TempDir(), "issues.gob")
+	cfg.Storage.Local.Index = index
+	s, err := NewLocalStore(cfg)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	return s, cfg
+}
+
+func testIssue(number int, title string) *github.Issue {
+	return &github.Issue{Number: github.Int(number), Title: github.String(title), Body: github.String(title + " body")}
+}
+
+// searchIDs returns the issues of owner/repo nearest to vec, nearest first
+func searchIDs(t *testing.T, s *LocalStore, vec []float64, topK int) []int64 {
+	t.Helper()
+	var ids []int64
+	for _, hit := range search(t, s, vec, SearchOptions{Repos: []string{"owner/repo"}, TopK: topK, MaxChunks: 2}) {
+		ids = append(ids, hit.IssueID)
+	}
+	return ids
+}
+
+// search returns the hits for vec matching opts
+func search(t *testing.T, s *LocalStore, vec []float64, opts SearchOptions) []SimilarIssue {
+	t.Helper()
+	hits, err := s.SearchSimilarIssues(context.Background(), [][]float64{vec}, opts)
+	if err != nil {
+		t.Fatalf("SearchSimilarIssues: %v", err)
+	}
+	return hits
+}
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, index := range []string{"flat", "hnsw"} {
+		t.Run(index, func(t *testing.T) {
+			s, cfg := newTestLocalStore(t, index)
+			// Issue 1 has a second chunk close to its first
+			chunks := map[int][]Chunk{
+				1: {{Text: "a", Embedding: []float64{1, 0, 0}}, {Text: "a2", Embedding: []float64{0.9, 0.1, 0}}},
+				2: {{Text: "b", Embedding: []float64{0, 1, 0}}},
+				3: {{Text: "c", Embedding: []float64{0, 0, 1}}},
+			}
+			// Stored with GitHub's casing and looked up in lower case below
+			for number := 1; number <= 3; number++ {
+				if err := s.InsertIssueVector(ctx, testIssue(number, "issue"), "Owner/Repo", chunks[number]); err != nil {
+					t.Fatalf("InsertIssueVector #%d: %v", number, err)
+				}
+			}
+
+			if got, want := searchIDs(t, s, []float64{1, 0.1, 0}, 2), []int64{1, 2}; !reflect.DeepEqual(got, want) {
+				t.Errorf("search = %v, want %v", got, want)
+			}
+
+			row, err := s.GetIssueVector(ctx, "owner/repo", 1)
+			if err != nil {
+				t.Fatalf("GetIssueVector: %v", err)
+			}
+			if row.Chunk != 0 || row.NormalizedText != "a" || row.Body != "issue body" || !reflect.DeepEqual(row.Embedding, chunks[1][0].Embedding) {
+				t.Errorf("GetIssueVector = %+v, want the first chunk of the inserted issue", row)
+			}
+
+			// Issue 3 now points the other way; the index must follow
+			if err := s.UpdateIssueVector(ctx, testIssue(3, "edited"), "owner/repo", []Chunk{{Text: "edited", Embedding: []float64{1, 0.1, 0}}}); err != nil {
+				t.Fatalf("UpdateIssueVector: %v", err)
+			}
+			if got, want := searchIDs(t, s, []float64{1, 0.1, 0}, 2), []int64{3, 1}; !reflect.DeepEqual(got, want) {
+				t.Errorf("search after update = %v, want %v", got, want)
+			}
+
+			if err := s.SetIssueState(ctx, "owner/repo", 1, StateClosed, "completed"); err != nil {
+				t.Fatalf("SetIssueState: %v", err)
+			}
+			open := SearchOptions{Repos: []string{"owner/repo"}, TopK: 5, MaxChunks: 2, OpenOnly: true}
+			for _, hit := range search(t, s, []float64{1, 0, 0}, open) {
+				if hit.IssueID == 1 {
+					t.Errorf("OpenOnly search returned closed issue 1")
+				}
+			}
+
+			if err := s.TransferIssueVector(ctx, "owner/repo", 2, "owner/other", 20); err != nil {
+				t.Fatalf("TransferIssueVector: %v", err)
+			}
+			if _, err := s.GetIssueVector(ctx, "owner/other", 20); err != nil {
+				t.Errorf("GetIssueVector after transfer: %v", err)
+			}
+			if err := s.DeleteIssueVector(ctx, "owner/other", 20); err != nil {
+				t.Fatalf("DeleteIssueVector: %v", err)
+			}
+
+			// Everything above must survive reopening the data file
+			reopened, err := NewLocalStore(cfg)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			row, err = reopened.GetIssueVector(ctx, "owner/repo", 3)
+			if err != nil || row.Title != "edited" {
+				t.Errorf("reopened GetIssueVector = %+v, %v, want the edited issue", row, err)
+			}
+			row, err = reopened.GetIssueVector(ctx, "owner/repo", 1)
+			if err != nil || row.State != StateClosed || row.StateReason != "completed" {
+				t.Errorf("reopened GetIssueVector = %+v, %v, want closed/completed", row, err)
+			}
+			for _, missing := range []struct {
+				repo string
+				id   int64
+			}{{"owner/repo", 2}, {"owner/other", 20}} {
+				if _, err := reopened.GetIssueVector(ctx, missing.repo, missing.id); !errors.Is(err, ErrNotFound) {
+					t.Errorf("reopened GetIssueVector(%s#%d) error = %v, want ErrNotFound", missing.repo, missing.id, err)
+				}
+			}
+			if got := searchIDs(t, reopened, []float64{0, 1, 0}, 5); len(got) != 2 {
+				t.Errorf("reopened search = %v, want the 2 remaining issues", got)
+			}
+		})
+	}
+}
+
+func TestLocalStoreSearchScope(t *testing.T) {
+	ctx := context.Background()
+	for _, index := range []string{"flat", "hnsw"} {
+		s, _ := newTestLocalStore(t, index)
+		stored := []struct {
+			repo   string
+			number int
+		}{{"owner/repo", 1}, {"Owner/Other", 2}, {"third/repo", 3}}
+		for _, is := range stored {
+			if err := s.InsertIssueVector(ctx, testIssue(is.number, "issue"), is.repo, []Chunk{{Embedding: []float64{1, float64(is.number)}}}); err != nil {
+				t.Fatalf("InsertIssueVector: %v", err)
+			}
+		}
+		tests := []struct {
+			name  string
+			repos []string
+			want  []string
+		}{
+			{"own repository", []string{"owner/repo"}, []string{"owner/repo#1"}},
+			{"group, case-insensitive", []string{"owner/repo", "owner/other"}, []string{"owner/repo#1", "Owner/Other#2"}},
+			{"unknown repository", []string{"nobody/none"}, nil},
+			{"no repositories", nil, nil},
+		}
+		for _, tt := range tests {
+			t.Run(index+"/"+tt.name, func(t *testing.T) {
+				var got []string
+				for _, hit := range search(t, s, []float64{1, 0}, SearchOptions{Repos: tt.repos, TopK: 5}) {
+					got = append(got, fmt.Sprintf("%s#%d", hit.Repo, hit.IssueID))
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("search in %v = %v, want %v", tt.repos, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestLocalStoreTransferReplacesDestination(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestLocalStore(t, "flat")
+	// A stale copy of the issue already exists in the destination repository
+	if err := s.InsertIssueVector(ctx, testIssue(5, "stale"), "owner/other", []Chunk{{Embedding: []float64{0, 1}}, {Embedding: []float64{0, 1}}}); err != nil {
+		t.Fatalf("InsertIssueVector: %v", err)
+	}
+	if err := s.InsertIssueVector(ctx, testIssue(1, "moved"), "owner/repo", []Chunk{{Embedding: []float64{1, 0}}}); err != nil {
+		t.Fatalf("InsertIssueVector: %v", err)
+	}
+	if err := s.TransferIssueVector(ctx, "owner/repo", 1, "Owner/Other", 5); err != nil {
+		t.Fatalf("TransferIssueVector: %v", err)
+	}
+
+	hits := search(t, s, []float64{0, 1}, SearchOptions{Repos: []string{"owner/other"}, TopK: 5, MaxChunks: 2})
+	if len(hits) != 1 || hits[0].IssueID != 5 {
+		t.Fatalf("search = %+v, want only issue 5", hits)
+	}
+	row, err := s.GetIssueVector(ctx, "owner/other", 5)
+	if err != nil || row.Title != "moved" {
+		t.Errorf("GetIssueVector = %+v, %v, want the transferred issue", row, err)
+	}
+	if len(s.rows) != 1 {
+		t.Errorf("store has %d rows, want the transferred row only", len(s.rows))
+	}
+}
+
+func TestLocalStoreNotFound(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestLocalStore(t, "flat")
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"update", func() error {
+			return s.UpdateIssueVector(ctx, testIssue(9, "x"), "owner/repo", []Chunk{{Embedding: []float64{1}}})
+		}},
+		{"get", func() error { _, err := s.GetIssueVector(ctx, "owner/repo", 9); return err }},
+		{"delete", func() error { return s.DeleteIssueVector(ctx, "owner/repo", 9) }},
+		{"set state", func() error { return s.SetIssueState(ctx, "owner/repo", 9, StateClosed, "") }},
+		{"transfer", func() error { return s.TransferIssueVector(ctx, "owner/repo", 9, "owner/other", 1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, ErrNotFound) {
+				t.Errorf("error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/google/go-github/v62/github"
+	"github.com/lib/pq"
+)
+
+// PGClient stores issue vectors in PostgreSQL using the pgvector extension
+type PGClient struct {
+	db    *sql.DB
+	cfg   *config.Config
+	table string
+}
+
+// Ensure PGClient satisfies VectorStore
+var _ VectorStore = (*PGClient)(nil)
+
+// pgOperator maps the configured distance metric to a pgvector operator and operator class.
+// <#> returns the negative inner product, so smaller is more similar for every metric.
+func pgOperator(metric Metric) (op, opClass string) {
+	switch metric {
+	case MetricDotProduct:
+		return "<#>", "vector_ip_ops"
+	case MetricEuclidean:
+		return "<->", "vector_l2_ops"
+	default:
+		return "<=>", "vector_cosine_ops"
+	}
+}
+
+// NewPGClient connects to PostgreSQL and creates the table and vector index if missing
+func NewPGClient(ctx context.Context, cfg *config.Config) (*PGClient, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		dsn = cfg.Storage.Postgres.DSN
+	}
+	if dsn == "" {
+		return nil, errors.New("postgres: no DSN configured (set DATABASE_URL or storage.postgres.dsn)")
+	}
+	table := cfg.Storage.Postgres.Table
+	if table == "" {
+		table = "issues_vectors"
+	}
+
+	slog.Debug("Initializing PostgreSQL client", "table", table)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: open: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("postgres: ping: %w", err)
+	}
+
+	p := &PGClient{db: db, cfg: cfg, table: table}
+	if err := p.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	slog.Info("PostgreSQL client initialized", "table", table)
+	return p, nil
+}
+
+// Ping checks that the database is reachable
+func (p *PGClient) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// Close closes the database connection pool
+func (p *PGClient) Close() error {
+	slog.Debug("Closing PostgreSQL client")
+	return p.db.Close()
+}
+
+// migrate creates the pgvector extension, table and index if they do not exist
+func (p *PGClient) migrate(ctx context.Context) error {
+	dims := p.cfg.GCP.VectorSearch.Dimensions
+	if dims <= 0 {
+		return errors.New("postgres: gcp.vector_search.dimensions must be set for the vector column")
+	}
+	_, opClass := pgOperator(ParseMetric(p.cfg.GCP.VectorSearch.Distance))
+	indexType := strings.ToLower(p.cfg.Storage.Postgres.IndexType)
+	if indexType == "" {
+		indexType = "hnsw"
+	}
+	if indexType != "hnsw" && indexType != "ivfflat" {
+		return fmt.Errorf("postgres: unsupported index type %q", p.cfg.Storage.Postgres.IndexType)
+	}
+
+	stmts := []string{
+		`CREATE EXTENSION IF NOT EXISTS vector`,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+            repo       TEXT NOT NULL,
+            issue_id   BIGINT NOT NULL,
+            title      TEXT NOT NULL DEFAULT '',
+            body       TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ,
+            embedding  vector(%d) NOT NULL
+        )`, pq.QuoteIdentifier(p.table), dims),
+		fmt.Sprintf(`ALTER TABLE %s
+            ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'open',
+            ADD COLUMN IF NOT EXISTS state_reason TEXT NOT NULL DEFAULT '',
+            ADD COLUMN IF NOT EXISTS chunk_index INT NOT NULL DEFAULT 0,
+            ADD COLUMN IF NOT EXISTS normalized_text TEXT NOT NULL DEFAULT ''`, pq.QuoteIdentifier(p.table)),
+		// Tables created before chunking have one row per issue as their primary key
+		fmt.Sprintf(`ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s`,
+			pq.QuoteIdentifier(p.table), pq.QuoteIdentifier(p.table+"_pkey")),
+		fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (repo, issue_id, chunk_index)`,
+			pq.QuoteIdentifier(p.table+"_chunk_key"), pq.QuoteIdentifier(p.table)),
+		// Repository names are matched case-insensitively, as GitHub does
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (LOWER(repo), issue_id)`,
+			pq.QuoteIdentifier(p.table+"_repo_idx"), pq.QuoteIdentifier(p.table)),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s USING %s (embedding %s)`,
+			pq.QuoteIdentifier(p.table+"_embedding_idx"), pq.QuoteIdentifier(p.table), indexType, opClass),
+	}
+	slog.DebugContext(ctx, "Running PostgreSQL schema migration", "index_type", indexType, "op_class", opClass)
+	for _, stmt := range stmts {
+		if _, err := p.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("postgres: migrate: %w", err)
+		}
+	}
+	return nil
+}
+
+// SearchSimilarIssues searches for similar issues within opts based on vector distance
+func (p *PGClient) SearchSimilarIssues(ctx context.Context, vecs [][]float64, opts SearchOptions) ([]SimilarIssue, error) {
+	results, err := searchChunks(ctx, vecs, opts, func(ctx context.Context, vec []float64, limit int) ([]SimilarIssue, error) {
+		return p.searchChunk(ctx, vec, limit, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "Read similar issues from PostgreSQL", "count", len(results))
+	return results, nil
+}
+
+// searchChunk returns the topK chunk rows nearest to vec within opts
+func (p *PGClient) searchChunk(ctx context.Context, vec []float64, topK int, opts SearchOptions) ([]SimilarIssue, error) {
+	op, _ := pgOperator(ParseMetric(p.cfg.GCP.VectorSearch.Distance))
+	slog.DebugContext(ctx, "Executing pgvector search", "operator", op, "top_k", topK, "repos", opts.Repos, "open_only", opts.OpenOnly)
+
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT repo, issue_id, embedding %s $1::vector AS dist, state, state_reason
+        FROM %s
+        WHERE LOWER(repo) = ANY($2) AND (NOT $4 OR state = 'open')
+        ORDER BY dist
+        LIMIT $3`, op, pq.QuoteIdentifier(p.table)), formatVector(vec), pq.Array(lowerRepos(opts.Repos)), topK, opts.OpenOnly)
+	if err != nil {
+		slog.ErrorContext(ctx, "PostgreSQL query execution failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SimilarIssue
+	for rows.Next() {
+		var r SimilarIssue
+		if err := rows.Scan(&r.Repo, &r.IssueID, &r.Distance, &r.State, &r.StateReason); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Error reading PostgreSQL results", "error", err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// InsertIssueVector stores issue data with one row per chunk, replacing any existing rows
+func (p *PGClient) InsertIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error {
+	slog.DebugContext(ctx, "Inserting issue vectors into PostgreSQL", "repo", repo, "issue", issue.GetNumber(), "chunks", len(chunks))
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		return p.replaceChunks(ctx, tx, newChunkRows(issue, repo, chunks))
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "PostgreSQL insertion failed", "repo", repo, "issue", issue.GetNumber(), "error", err)
+	}
+	return err
+}
+
+// UpdateIssueVector replaces the stored title, body and chunks of an issue,
+// keeping its creation time and state
+func (p *PGClient) UpdateIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error {
+	slog.DebugContext(ctx, "Updating issue vectors in PostgreSQL", "repo", repo, "issue", issue.GetNumber(), "chunks", len(chunks))
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		var createdAt sql.NullTime
+		var state, reason string
+		err := tx.QueryRowContext(ctx, fmt.Sprintf(`
+            SELECT created_at, state, state_reason FROM %s
+            WHERE LOWER(repo) = LOWER($1) AND issue_id = $2
+            ORDER BY chunk_index LIMIT 1 FOR UPDATE`, pq.QuoteIdentifier(p.table)),
+			repo, int64(issue.GetNumber())).Scan(&createdAt, &state, &reason)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		rows := newChunkRows(issue, repo, chunks)
+		for _, row := range rows {
+			row.CreatedAt, row.State, row.StateReason = createdAt.Time, state, reason
+		}
+		return p.replaceChunks(ctx, tx, rows)
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		slog.ErrorContext(ctx, "PostgreSQL update failed", "repo", repo, "issue", issue.GetNumber(), "error", err)
+	}
+	return err
+}
+
+// replaceChunks deletes the stored rows of the issue of rows and inserts rows in their place
+func (p *PGClient) replaceChunks(ctx context.Context, tx *sql.Tx, rows []*IssueRow) error {
+	if len(rows) == 0 {
+		return errors.New("postgres: no chunks to store")
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE LOWER(repo) = LOWER($1) AND issue_id = $2`,
+		pq.QuoteIdentifier(p.table)), rows[0].Repo, rows[0].IssueID); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+            INSERT INTO %s (repo, issue_id, chunk_index, title, body, created_at, embedding, normalized_text, state, state_reason)
+            VALUES ($1, $2, $3, $4, $5, $6, $7::vector, $8, $9, $10)`, pq.QuoteIdentifier(p.table)),
+			row.Repo, row.IssueID, row.Chunk, row.Title, row.Body, row.CreatedAt, formatVector(row.Embedding), row.NormalizedText, row.State, row.StateReason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committing if it succeeds
+func (p *PGClient) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteIssueVector removes the stored vectors of an issue, or returns ErrNotFound
+func (p *PGClient) DeleteIssueVector(ctx context.Context, repo string, issueID int64) error {
+	slog.DebugContext(ctx, "Deleting issue vector from PostgreSQL", "repo", repo, "issue", issueID)
+	res, err := p.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE LOWER(repo) = LOWER($1) AND issue_id = $2`,
+		pq.QuoteIdentifier(p.table)), repo, issueID)
+	if err == nil {
+		err = notFoundIfNone(res)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		slog.ErrorContext(ctx, "PostgreSQL delete failed", "repo", repo, "issue", issueID, "error", err)
+	}
+	return err
+}
+
+// GetIssueVector returns the stored row of an issue's first chunk, or ErrNotFound
+func (p *PGClient) GetIssueVector(ctx context.Context, repo string, issueID int64) (*IssueRow, error) {
+	var row IssueRow
+	var createdAt sql.NullTime
+	var embedding string
+	err := p.db.QueryRowContext(ctx, fmt.Sprintf(`
+        SELECT repo, issue_id, chunk_index, title, body, created_at, embedding::text, normalized_text, state, state_reason
+        FROM %s WHERE LOWER(repo) = LOWER($1) AND issue_id = $2
+        ORDER BY chunk_index LIMIT 1`, pq.QuoteIdentifier(p.table)),
+		repo, issueID).Scan(&row.Repo, &row.IssueID, &row.Chunk, &row.Title, &row.Body, &createdAt, &embedding, &row.NormalizedText, &row.State, &row.StateReason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "PostgreSQL query execution failed", "repo", repo, "issue", issueID, "error", err)
+		return nil, err
+	}
+	row.CreatedAt = createdAt.Time
+	if row.Embedding, err = parseVector(embedding); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// SetIssueState records that an issue was closed (with a reason) or reopened
+func (p *PGClient) SetIssueState(ctx context.Context, repo string, issueID int64, state, reason string) error {
+	slog.DebugContext(ctx, "Setting issue state in PostgreSQL", "repo", repo, "issue", issueID, "state", state, "state_reason", reason)
+	res, err := p.db.ExecContext(ctx, fmt.Sprintf(`
+        UPDATE %s SET state = $3, state_reason = $4
+        WHERE LOWER(repo) = LOWER($1) AND issue_id = $2`, pq.QuoteIdentifier(p.table)),
+		repo, issueID, state, reason)
+	if err == nil {
+		err = notFoundIfNone(res)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		slog.ErrorContext(ctx, "PostgreSQL state update failed", "repo", repo, "issue", issueID, "error", err)
+	}
+	return err
+}
+
+// TransferIssueVector moves the stored vectors of an issue to its new repository and number
+func (p *PGClient) TransferIssueVector(ctx context.Context, fromRepo string, fromID int64, toRepo string, toID int64) error {
+	slog.DebugContext(ctx, "Transferring issue vector in PostgreSQL", "from", fmt.Sprintf("%s#%d", fromRepo, fromID), "to", fmt.Sprintf("%s#%d", toRepo, toID))
+	res, err := p.db.ExecContext(ctx, fmt.Sprintf(`
+        UPDATE %s SET repo = $3, issue_id = $4
+        WHERE LOWER(repo) = LOWER($1) AND issue_id = $2`, pq.QuoteIdentifier(p.table)),
+		fromRepo, fromID, toRepo, toID)
+	if err == nil {
+		err = notFoundIfNone(res)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		slog.ErrorContext(ctx, "PostgreSQL transfer failed", "from", fmt.Sprintf("%s#%d", fromRepo, fromID), "error", err)
+	}
+	return err
+}
+
+// notFoundIfNone returns ErrNotFound if a statement changed no rows
+func notFoundIfNone(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// formatVector encodes a vector in pgvector's text representation, e.g. [0.1,0.2]
+func formatVector(vec []float64) string {
+	parts := make([]string, len(vec))
+	for i, v := range vec {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// parseVector decodes pgvector's text representation
+func parseVector(s string) ([]float64, error) {
+	s = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "["), "]")
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	vec := make([]float64, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: parse vector: %w", err)
+		}
+		vec[i] = v
+	}
+	return vec, nil
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/lib/pq"
+)
+
+func TestPGOperator(t *testing.T) {
+	tests := []struct {
+		metric      Metric
+		wantOp      string
+		wantOpClass string
+	}{
+		{MetricCosine, "<=>", "vector_cosine_ops"},
+		{MetricDotProduct, "<#>", "vector_ip_ops"},
+		{MetricEuclidean, "<->", "vector_l2_ops"},
+		{ParseMetric("unknown"), "<=>", "vector_cosine_ops"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.metric), func(t *testing.T) {
+			op, opClass := pgOperator(tt.metric)
+			if op != tt.wantOp || opClass != tt.wantOpClass {
+				t.Errorf("pgOperator(%s) = %q, %q, want %q, %q", tt.metric, op, opClass, tt.wantOp, tt.wantOpClass)
+			}
+		})
+	}
+}
+
+// newTestPGClient connects to the database in DUPRADAR_TEST_PG_DSN with a fresh table
+// that is dropped after the test. Tests using it are skipped without the variable.
+func newTestPGClient(t *testing.T, distance string) *PGClient {
+	t.Helper()
+	dsn := os.Getenv("DUPRADAR_TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("DUPRADAR_TEST_PG_DSN is not set")
+	}
+	t.Setenv("DATABASE_URL", "")
+	cfg := &config.Config{}
+	cfg.GCP.VectorSearch.Distance = distance
+	cfg.GCP.VectorSearch.Dimensions = 3
+	cfg.Storage.Postgres.DSN = dsn
+	cfg.Storage.Postgres.Table = fmt.Sprintf("dupradar_test_%d", time.Now().UnixNano())
+
+	p, err := NewPGClient(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewPGClient: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := p.db.Exec("DROP TABLE IF EXISTS " + pq.QuoteIdentifier(p.table)); err != nil {
+			t.Errorf("drop test table: %v", err)
+		}
+		p.Close()
+	})
+	return p
+}
+
+func TestPGMigration(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPGClient(t, "COSINE")
+	// Running the migration again on an existing table must succeed
+	if err := p.migrate(ctx); err != nil {
+		t.Fatalf("second migrate: %v", err)
+	}
+
+	rows, err := p.db.QueryContext(ctx, `SELECT column_name FROM information_schema.columns WHERE table_name = $1`, p.table)
+	if err != nil {
+		t.Fatalf("read columns: %v", err)
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		columns[name] = true
+	}
+	for _, want := range []string{"repo", "issue_id", "chunk_index", "title", "body", "created_at", "embedding", "normalized_text", "state", "state_reason"} {
+		if !columns[want] {
+			t.Errorf("column %s is missing", want)
+		}
+	}
+
+	var opClass string
+	err = p.db.QueryRowContext(ctx, `
+        SELECT opc.opcname FROM pg_index i
+        JOIN pg_class c ON c.oid = i.indexrelid
+        JOIN pg_opclass opc ON opc.oid = i.indclass[0]
+        WHERE c.relname = $1`, p.table+"_embedding_idx").Scan(&opClass)
+	if err != nil || opClass != "vector_cosine_ops" {
+		t.Errorf("embedding index operator class = %q, %v, want vector_cosine_ops", opClass, err)
+	}
+}
+
+func TestPGSearchDistances(t *testing.T) {
+	ctx := context.Background()
+	query := []float64{1, 0.5, 0}
+	stored := map[int][]float64{1: {1, 0, 0}, 2: {0, 1, 0}, 3: {2, 2, 0}}
+	for _, metric := range []Metric{MetricCosine, MetricDotProduct, MetricEuclidean} {
+		t.Run(string(metric), func(t *testing.T) {
+			p := newTestPGClient(t, string(metric))
+			for number, vec := range stored {
+				if err := p.InsertIssueVector(ctx, testIssue(number, "issue"), "owner/repo", []Chunk{{Embedding: vec}}); err != nil {
+					t.Fatalf("InsertIssueVector: %v", err)
+				}
+			}
+			hits, err := p.SearchSimilarIssues(ctx, [][]float64{query}, SearchOptions{Repos: []string{"owner/repo"}, TopK: 3})
+			if err != nil {
+				t.Fatalf("SearchSimilarIssues: %v", err)
+			}
+			if len(hits) != len(stored) {
+				t.Fatalf("got %d hits, want %d", len(hits), len(stored))
+			}
+			// The database must agree with the in-process distance of the metric
+			dist := metric.distanceFunc()
+			for i, hit := range hits {
+				if want := dist(query, stored[int(hit.IssueID)]); math.Abs(hit.Distance-want) > 1e-5 {
+					t.Errorf("distance of issue %d = %v, want %v", hit.IssueID, hit.Distance, want)
+				}
+				if i > 0 && hit.Distance < hits[i-1].Distance {
+					t.Errorf("hits are not ordered by distance: %+v", hits)
+				}
+			}
+		})
+	}
+}
+
+func TestPGSearchFilters(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPGClient(t, "COSINE")
+	for _, is := range []struct {
+		repo   string
+		number int
+	}{{"owner/repo", 1}, {"owner/repo", 2}, {"Owner/Other", 3}} {
+		if err := p.InsertIssueVector(ctx, testIssue(is.number, "issue"), is.repo, []Chunk{{Embedding: []float64{1, float64(is.number), 0}}}); err != nil {
+			t.Fatalf("InsertIssueVector: %v", err)
+		}
+	}
+	if err := p.SetIssueState(ctx, "Owner/Repo", 2, StateClosed, "completed"); err != nil {
+		t.Fatalf("SetIssueState: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want []int64
+	}{
+		{"own repository", SearchOptions{Repos: []string{"owner/repo"}}, []int64{1, 2}},
+		{"repository group, case-insensitive", SearchOptions{Repos: []string{"owner/repo", "owner/other"}}, []int64{1, 2, 3}},
+		{"open only", SearchOptions{Repos: []string{"owner/repo"}, OpenOnly: true}, []int64{1}},
+		{"unknown repository", SearchOptions{Repos: []string{"nobody/none"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.TopK = 5
+			hits, err := p.SearchSimilarIssues(ctx, [][]float64{{1, 0, 0}}, tt.opts)
+			if err != nil {
+				t.Fatalf("SearchSimilarIssues: %v", err)
+			}
+			var got []int64
+			for _, hit := range hits {
+				got = append(got, hit.IssueID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("search = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPGLookupsIgnoreRepoCase(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPGClient(t, "COSINE")
+	if err := p.InsertIssueVector(ctx, testIssue(1, "issue"), "Owner/Repo", []Chunk{{Embedding: []float64{1, 0, 0}}}); err != nil {
+		t.Fatalf("InsertIssueVector: %v", err)
+	}
+	if err := p.UpdateIssueVector(ctx, testIssue(1, "edited"), "owner/repo", []Chunk{{Embedding: []float64{0, 1, 0}}}); err != nil {
+		t.Fatalf("UpdateIssueVector: %v", err)
+	}
+	row, err := p.GetIssueVector(ctx, "OWNER/REPO", 1)
+	if err != nil || row.Title != "edited" {
+		t.Errorf("GetIssueVector = %+v, %v, want the updated issue", row, err)
+	}
+	if err := p.DeleteIssueVector(ctx, "owner/repo", 1); err != nil {
+		t.Errorf("DeleteIssueVector: %v", err)
+	}
+}
+
+func TestPGNotFound(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPGClient(t, "COSINE")
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"update", func() error {
+			return p.UpdateIssueVector(ctx, testIssue(9, "x"), "owner/repo", []Chunk{{Embedding: []float64{1, 0, 0}}})
+		}},
+		{"get", func() error { _, err := p.GetIssueVector(ctx, "owner/repo", 9); return err }},
+		{"delete", func() error { return p.DeleteIssueVector(ctx, "owner/repo", 9) }},
+		{"set state", func() error { return p.SetIssueState(ctx, "owner/repo", 9, StateClosed, "") }},
+		{"transfer", func() error { return p.TransferIssueVector(ctx, "owner/repo", 9, "owner/other", 1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, ErrNotFound) {
+				t.Errorf("error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
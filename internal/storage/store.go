@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/google/go-github/v62/github"
+)
+
+// ErrNotFound is returned when no stored vector exists for the requested issue
+var ErrNotFound = errors.New("storage: issue vector not found")
+
+// Issue states tracked alongside the stored vectors
+const (
+	StateOpen   = "open"
+	StateClosed = "closed"
+)
+
+// SimilarIssue is a single vector search hit
+type SimilarIssue struct {
+	Repo        string  `bigquery:"repo"`
+	IssueID     int64   `bigquery:"issue_id"`
+	Distance    float64 `bigquery:"dist"`
+	State       string  `bigquery:"state"`        // open or closed
+	StateReason string  `bigquery:"state_reason"` // e.g. completed, not_planned, duplicate
+}
+
+// Closed reports whether the hit refers to a closed issue
+func (s SimilarIssue) Closed() bool {
+	return s.State == StateClosed
+}
+
+// SearchOptions narrows a similarity search
+type SearchOptions struct {
+	Repos    []string // Repositories to search (owner/repo)
+	TopK     int      // Maximum number of hits
+	OpenOnly bool     // Skip closed issues
+	// Chunks stored per issue at most; each query vector fetches TopK*MaxChunks chunk hits
+	// so that TopK distinct issues remain after aggregation
+	MaxChunks int
+	// How the distances of an issue's chunk hits become its distance: max similarity
+	// (the nearest chunk, default) or mean
+	Aggregate string
+}
+
+// VectorStore abstracts the vector database used by the duplicate detection pipeline.
+// BQClient is the default implementation; other backends only need to satisfy this interface.
+type VectorStore interface {
+	// SearchSimilarIssues returns the issues whose chunks are nearest to any of the query
+	// vectors within opts, one hit per issue, ordered from most to least similar
+	SearchSimilarIssues(ctx context.Context, vecs [][]float64, opts SearchOptions) ([]SimilarIssue, error)
+	// InsertIssueVector stores an issue with one row per chunk, replacing any
+	// existing rows for the same repo and issue_id
+	InsertIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error
+	// UpdateIssueVector replaces the stored title, body and chunks of an existing issue
+	UpdateIssueVector(ctx context.Context, issue *github.Issue, repo string, chunks []Chunk) error
+	// DeleteIssueVector removes the stored vectors of an issue, or returns ErrNotFound
+	DeleteIssueVector(ctx context.Context, repo string, issueID int64) error
+	// GetIssueVector returns the stored row of an issue's first chunk, or ErrNotFound
+	GetIssueVector(ctx context.Context, repo string, issueID int64) (*IssueRow, error)
+	// SetIssueState records that an issue was closed (with a reason) or reopened
+	SetIssueState(ctx context.Context, repo string, issueID int64, state, reason string) error
+	// TransferIssueVector moves the stored vectors of an issue to its new repository and number
+	TransferIssueVector(ctx context.Context, fromRepo string, fromID int64, toRepo string, toID int64) error
+	// Ping checks that the backing database is reachable, for readiness probes
+	Ping(ctx context.Context) error
+	// Close releases the store's connections; it is called once during shutdown
+	Close() error
+}
+
+// stateOrOpen returns the row's state, treating rows stored before state tracking as open
+func (r *IssueRow) stateOrOpen() string {
+	if r.State == "" {
+		return StateOpen
+	}
+	return r.State
+}
+
+// newIssueRow builds the stored row for an issue
+func newIssueRow(issue *github.Issue, repo string, vec []float64) *IssueRow {
+	state := issue.GetState()
+	if state == "" {
+		state = StateOpen
+	}
+	return &IssueRow{
+		Repo:        repo,
+		IssueID:     int64(issue.GetNumber()),
+		Title:       issue.GetTitle(),
+		Body:        issue.GetBody(),
+		CreatedAt:   issue.GetCreatedAt().Time,
+		Embedding:   vec,
+		State:       state,
+		StateReason: issue.GetStateReason(),
+	}
+}
+
+// Chunk is one embedded piece of an issue
+type Chunk struct {
+	Text      string // Preprocessed text that was embedded, stored for debugging
+	Embedding []float64
+}
+
+// lowerRepos lowercases repository names for case-insensitive matching, as GitHub treats them
+func lowerRepos(repos []string) []string {
+	lower := make([]string, len(repos))
+	for i, r := range repos {
+		lower[i] = strings.ToLower(r)
+	}
+	return lower
+}
+
+// newChunkRows builds the stored rows of an issue, one per chunk.
+// Only chunk 0 carries the body, which is not repeated for every chunk.
+func newChunkRows(issue *github.Issue, repo string, chunks []Chunk) []*IssueRow {
+	rows := make([]*IssueRow, len(chunks))
+	for i, c := range chunks {
+		row := newIssueRow(issue, repo, c.Embedding)
+		row.Chunk = int64(i)
+		row.NormalizedText = c.Text
+		if i > 0 {
+			row.Body = ""
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// searchChunks runs search for every query vector, fetching enough chunk hits for
+// opts.TopK issues, and aggregates the hits into one per issue
+func searchChunks(ctx context.Context, vecs [][]float64, opts SearchOptions, search func(ctx context.Context, vec []float64, limit int) ([]SimilarIssue, error)) ([]SimilarIssue, error) {
+	limit := opts.TopK * max(opts.MaxChunks, 1)
+	var hits []SimilarIssue
+	for _, vec := range vecs {
+		h, err := search(ctx, vec, limit)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, h...)
+	}
+	return aggregateHits(hits, opts.Aggregate, opts.TopK), nil
+}
+
+// aggregateHits merges chunk hits of the same issue, taking the smallest distance
+// (max similarity) or the mean distance, and returns the topK nearest issues
+func aggregateHits(hits []SimilarIssue, aggregate string, topK int) []SimilarIssue {
+	type issueKey struct {
+		repo string
+		id   int64
+	}
+	var issues []SimilarIssue
+	byIssue := make(map[issueKey]int)
+	counts := make([]int, 0, len(hits))
+	for _, h := range hits {
+		k := issueKey{strings.ToLower(h.Repo), h.IssueID}
+		i, ok := byIssue[k]
+		if !ok {
+			byIssue[k] = len(issues)
+			issues = append(issues, h)
+			counts = append(counts, 1)
+			continue
+		}
+		if strings.EqualFold(aggregate, "mean") {
+			issues[i].Distance += h.Distance
+		} else {
+			issues[i].Distance = min(issues[i].Distance, h.Distance)
+		}
+		counts[i]++
+	}
+	if strings.EqualFold(aggregate, "mean") {
+		for i := range issues {
+			issues[i].Distance /= float64(counts[i])
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Distance < issues[j].Distance })
+	if len(issues) > topK {
+		issues = issues[:topK]
+	}
+	return issues
+}
+
+// Ensure BQClient satisfies VectorStore
+var _ VectorStore = (*BQClient)(nil)
+
+// New creates the vector store selected by cfg.Storage.Backend (bigquery by default)
+func New(ctx context.Context, cfg *config.Config) (VectorStore, error) {
+	switch strings.ToLower(cfg.Storage.Backend) {
+	case "", "bigquery":
+		return NewBQClient(ctx, cfg)
+	case "local":
+		return NewLocalStore(cfg)
+	case "postgres":
+		return NewPGClient(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
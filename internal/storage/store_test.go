@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAggregateHits(t *testing.T) {
+	hits := []SimilarIssue{
+		{Repo: "o/r", IssueID: 1, Distance: 0.4},
+		{Repo: "o/r", IssueID: 2, Distance: 0.2},
+		{Repo: "O/R", IssueID: 1, Distance: 0.1},
+		{Repo: "o/r", IssueID: 3, Distance: 0.3},
+	}
+	tests := []struct {
+		name      string
+		hits      []SimilarIssue
+		aggregate string
+		topK      int
+		wantIDs   []int64
+		wantDists []float64
+	}{
+		{"max takes the nearest chunk", hits, "max", 3, []int64{1, 2, 3}, []float64{0.1, 0.2, 0.3}},
+		{"mean averages the chunks", hits, "MEAN", 3, []int64{2, 1, 3}, []float64{0.2, 0.25, 0.3}},
+		{"empty aggregate is max", hits, "", 3, []int64{1, 2, 3}, []float64{0.1, 0.2, 0.3}},
+		{"topK cuts", hits, "max", 1, []int64{1}, []float64{0.1}},
+		{"no hits", nil, "max", 3, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateHits(append([]SimilarIssue(nil), tt.hits...), tt.aggregate, tt.topK)
+			if len(got) != len(tt.wantIDs) {
+				t.Fatalf("aggregateHits() = %+v, want issues %v", got, tt.wantIDs)
+			}
+			for i, hit := range got {
+				if hit.IssueID != tt.wantIDs[i] || math.Abs(hit.Distance-tt.wantDists[i]) > 1e-9 {
+					t.Errorf("hit %d = #%d at %v, want #%d at %v", i, hit.IssueID, hit.Distance, tt.wantIDs[i], tt.wantDists[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Package tracing sets up OpenTelemetry tracing and exports spans over OTLP/HTTP
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	"github.com/AobaIwaki123/dup-radar/internal/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/AobaIwaki123/dup-radar"
+
+// Setup installs the global tracer provider exporting to tracing.endpoint.
+// With no endpoint configured tracing stays disabled and spans are no-ops.
+// The returned function flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	tc := cfg.Tracing
+	if tc.Endpoint == "" {
+		slog.Info("Tracing disabled (no tracing.endpoint)")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{}
+	if strings.Contains(tc.Endpoint, "://") {
+		opts = append(opts, otlptracehttp.WithEndpointURL(tc.Endpoint))
+	} else {
+		opts = append(opts, otlptracehttp.WithEndpoint(tc.Endpoint))
+	}
+	if tc.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(tc.ServiceName),
+		semconv.ServiceVersion(version.Get().Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create trace resource: %w", err)
+	}
+
+	// Sample by trace ID so every span of a delivery gets the same decision
+	sampler := sdktrace.TraceIDRatioBased(tc.SampleRatio)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithIDGenerator(deliveryIDs{}),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("Exporting traces", "endpoint", tc.Endpoint, "sample_ratio", tc.SampleRatio)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx, or as a new trace
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type deliveryKey struct{}
+
+// WithDelivery makes root spans started from ctx belong to the trace of a webhook delivery,
+// so receiving the delivery and every processing attempt (including retries after a
+// restart or a dead-letter replay) show up as one trace
+func WithDelivery(ctx context.Context, deliveryID string) context.Context {
+	if deliveryID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, deliveryKey{}, deliveryID)
+}
+
+// DeliveryTraceID returns the trace ID used for a delivery: the delivery GUID itself,
+// or a hash of it when the ID is not a GUID
+func DeliveryTraceID(deliveryID string) trace.TraceID {
+	var id trace.TraceID
+	if b, err := hex.DecodeString(strings.ReplaceAll(deliveryID, "-", "")); err == nil && len(b) == len(id) {
+		copy(id[:], b)
+	} else {
+		sum := sha256.Sum256([]byte(deliveryID))
+		copy(id[:], sum[:])
+	}
+	return id
+}
+
+// deliveryIDs derives the trace ID of root spans from the delivery in their context
+// and generates random IDs otherwise
+type deliveryIDs struct{}
+
+func (deliveryIDs) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	var traceID trace.TraceID
+	if id, ok := ctx.Value(deliveryKey{}).(string); ok {
+		traceID = DeliveryTraceID(id)
+	} else {
+		_, _ = rand.Read(traceID[:])
+	}
+	return traceID, deliveryIDs{}.NewSpanID(ctx, traceID)
+}
+
+func (deliveryIDs) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	var spanID trace.SpanID
+	_, _ = rand.Read(spanID[:])
+	return spanID
+}
@@ -0,0 +1,40 @@
+// Package version reports build information for the /version endpoint
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is the release version, set at build time with
+// -ldflags "-X github.com/AobaIwaki123/dup-radar/internal/version.Version=v1.2.3"
+var Version = "dev"
+
+// Info describes the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // Built from a dirty working tree
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information, reading the VCS details embedded by the Go toolchain
+func Get() Info {
+	info := Info{Version: Version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			info.BuildTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/AobaIwaki123/dup-radar/internal/queue"
+)
+
+// registerAdmin adds the dead-letter endpoints when DUPRADAR_ADMIN_TOKEN is set:
+//
+//	GET  /admin/dead-letters             lists failed jobs
+//	POST /admin/dead-letters/replay?id=  moves a failed job back to the queue
+//
+// Requests must send "Authorization: Bearer <token>".
+func (h *Handler) registerAdmin(mux *http.ServeMux) {
+	token := os.Getenv("DUPRADAR_ADMIN_TOKEN")
+	if token == "" {
+		slog.Info("DUPRADAR_ADMIN_TOKEN not set, admin endpoints disabled")
+		return
+	}
+	mux.HandleFunc("/admin/dead-letters", requireToken(token, h.handleDeadLetters))
+	mux.HandleFunc("/admin/dead-letters/replay", requireToken(token, h.handleReplay))
+	slog.Info("Admin endpoints enabled")
+}
+
+// requireToken rejects requests without the admin bearer token
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleDeadLetters lists dead-lettered jobs without their payloads
+func (h *Handler) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	jobs, err := h.queue.DeadLetters()
+	if err != nil {
+		slog.Error("Failed to list dead letters", "error", err)
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		return
+	}
+	for _, job := range jobs {
+		job.Payload = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(jobs)
+}
+
+// handleReplay requeues a dead-lettered job
+func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	err := h.queue.Replay(id)
+	switch {
+	case errors.Is(err, queue.ErrJobNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("Failed to replay job", "delivery_id", id, "error", err)
+		http.Error(w, "Failed to replay job", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
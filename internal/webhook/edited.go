@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/AobaIwaki123/dup-radar/internal/config"
+	ghclient "github.com/AobaIwaki123/dup-radar/internal/github"
+	"github.com/AobaIwaki123/dup-radar/internal/metrics"
+	"github.com/AobaIwaki123/dup-radar/internal/queue"
+	"github.com/AobaIwaki123/dup-radar/internal/storage"
+	githubapi "github.com/google/go-github/v62/github"
+)
+
+// handleIssueEdited re-embeds an edited issue and updates its stored vector.
+// When github.recheck_on_edit is enabled, the duplicate check is re-run and
+// DupRadar's comment is created or edited if the candidate list changed.
+func (h *Handler) handleIssueEdited(ctx context.Context, job *queue.Job, evt *githubapi.IssuesEvent, rs config.RepoSettings) error {
+	repoFull := evt.GetRepo().GetFullName()
+	issue := evt.GetIssue()
+	issueNumber := issue.GetNumber()
+	slog.InfoContext(ctx, "Processing issue edit")
+
+	// 1) Re-embed
+	var vecs issueVectors
+	if err := h.runStep(ctx, job, stepEmbed, func(ctx context.Context) (err error) {
+		vecs, err = h.embedIssue(ctx, issue)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// 2) Re-check duplicates
+	if h.config().GitHub.RecheckOnEdit {
+		var similar []storage.SimilarIssue
+		if err := h.runStep(ctx, job, stepSearch, func(ctx context.Context) (err error) {
+			similar, err = h.searchSimilar(ctx, rs, repoFull, issueNumber, vecs.query)
+			return err
+		}); err != nil {
+			return err
+		}
+		if err := h.runStep(ctx, job, stepComment, func(ctx context.Context) error {
+			return h.refreshComment(ctx, evt, rs, similar)
+		}); err != nil {
+			return err
+		}
+		if h.buildComment(rs, repoFull, similar) != "" {
+			if err := h.labelIssue(ctx, job, evt, rs); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 3) Update vector
+	if err := h.runStep(ctx, job, stepUpdate, func(ctx context.Context) error {
+		return h.updateVector(ctx, issue, repoFull, vecs.document)
+	}); err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Updated embedding in vector store")
+	return nil
+}
+
+// refreshComment brings DupRadar's comment on an edited issue in line with the new candidates
+func (h *Handler) refreshComment(ctx context.Context, evt *githubapi.IssuesEvent, rs config.RepoSettings, similar []storage.SimilarIssue) error {
+	owner := evt.GetRepo().GetOwner().GetLogin()
+	repo := evt.GetRepo().GetName()
+	issueNumber := evt.GetIssue().GetNumber()
+
+	msg := h.buildComment(rs, evt.GetRepo().GetFullName(), similar)
+	existing, err := h.ghClient.FindDupRadarComment(ctx, owner, repo, issueNumber)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		if msg == "" {
+			slog.InfoContext(ctx, "No similar issues after edit and no previous comment, skipping")
+			return nil
+		}
+		return h.createComment(ctx, owner, repo, issueNumber, msg)
+	}
+
+	if msg == "" {
+		msg = ghclient.BuildNoLongerSimilarComment(rs.CommentLanguage)
+	}
+	if existing.GetBody() == msg {
+		slog.InfoContext(ctx, "Similar issue candidates unchanged, keeping comment")
+		return nil
+	}
+	if err := h.ghClient.EditIssueComment(ctx, owner, repo, existing.GetID(), msg); err != nil {
+		return err
+	}
+	metrics.CommentsPosted.WithLabelValues("edited").Inc()
+	return nil
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/AobaIwaki123/dup-radar/internal/queue"
+	"github.com/AobaIwaki123/dup-radar/internal/storage"
+	githubapi "github.com/google/go-github/v62/github"
+)
+
+// transferChanges holds the parts of an issues.transferred payload that go-github does not parse
+type transferChanges struct {
+	Changes struct {
+		NewIssue      *githubapi.Issue      `json:"new_issue"`
+		NewRepository *githubapi.Repository `json:"new_repository"`
+	} `json:"changes"`
+}
+
+// handleIssueLifecycle keeps the stored vector in sync with closed, reopened and deleted issues
+func (h *Handler) handleIssueLifecycle(ctx context.Context, job *queue.Job, evt *githubapi.IssuesEvent) error {
+	repoFull := evt.GetRepo().GetFullName()
+	issue := evt.GetIssue()
+	issueID := int64(issue.GetNumber())
+
+	err := h.runStep(ctx, job, stepSync, func(ctx context.Context) error {
+		var err error
+		switch evt.GetAction() {
+		case "closed":
+			err = h.store.SetIssueState(ctx, repoFull, issueID, storage.StateClosed, issue.GetStateReason())
+		case "reopened":
+			err = h.store.SetIssueState(ctx, repoFull, issueID, storage.StateOpen, "")
+		case "deleted":
+			err = h.store.DeleteIssueVector(ctx, repoFull, issueID)
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			return queue.Permanent(err)
+		}
+		return err
+	})
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		slog.InfoContext(ctx, "Issue is not indexed, nothing to sync")
+		return nil
+	case err != nil:
+		return err
+	default:
+		slog.InfoContext(ctx, "Synced issue state to vector store")
+		return nil
+	}
+}
+
+// handleIssueTransferred moves the stored vector of a transferred issue to its new repository
+func (h *Handler) handleIssueTransferred(ctx context.Context, job *queue.Job, evt *githubapi.IssuesEvent, changes transferChanges) error {
+	fromRepo := evt.GetRepo().GetFullName()
+	fromID := int64(evt.GetIssue().GetNumber())
+	toRepo := changes.Changes.NewRepository.GetFullName()
+	toID := int64(changes.Changes.NewIssue.GetNumber())
+	if toRepo == "" || toID == 0 {
+		return fmt.Errorf("transfer of %s#%d is missing the new repository or issue", fromRepo, fromID)
+	}
+
+	err := h.runStep(ctx, job, stepSync, func(ctx context.Context) error {
+		err := h.store.TransferIssueVector(ctx, fromRepo, fromID, toRepo, toID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return queue.Permanent(err)
+		}
+		return err
+	})
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		slog.InfoContext(ctx, "Issue is not indexed, nothing to transfer")
+		return nil
+	case err != nil:
+		return err
+	default:
+		slog.InfoContext(ctx, "Moved stored vector", "to", fmt.Sprintf("%s#%d", toRepo, toID))
+		return nil
+	}
+}
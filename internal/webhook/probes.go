@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AobaIwaki123/dup-radar/internal/version"
+)
+
+// probeTimeout bounds each dependency check of /readyz
+const probeTimeout = 5 * time.Second
+
+// registerProbes adds the endpoints used by Cloud Run / Kubernetes probes and operators:
+//
+//	GET /healthz  the process is alive
+//	GET /readyz   the vector store, the embedding provider and GitHub are reachable (cached)
+//	GET /version  build information
+func (h *Handler) registerProbes(mux *http.ServeMux) {
+	sc := h.config().Server
+	ready := &readiness{
+		ttl: sc.ReadyCacheTTL,
+		checks: map[string]func(ctx context.Context) error{
+			"store":     h.store.Ping,
+			"embedding": h.embedder.Check,
+			"github":    h.ghClient.Ping,
+		},
+	}
+	// Pinging the embedding provider creates a billed embedding, so it is opt-in
+	if sc.ReadyCheckEmbedding {
+		ready.checks["embedding"] = h.embedder.Ping
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", ready.serveHTTP)
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, version.Get())
+	})
+}
+
+// readiness runs the dependency checks of /readyz and caches the outcome for ttl,
+// so frequent probes do not hammer BigQuery, Vertex AI or the GitHub API
+type readiness struct {
+	ttl    time.Duration
+	checks map[string]func(ctx context.Context) error
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	results   map[string]string // "ok" or "fail" by check name; errors are only logged
+	ready     bool
+}
+
+func (rd *readiness) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	rd.mu.Lock()
+	if rd.results == nil || time.Since(rd.checkedAt) >= rd.ttl {
+		// Detached from the request so a disconnecting prober does not cache a failure
+		rd.run(context.WithoutCancel(r.Context()))
+	}
+	status, results := http.StatusOK, rd.results
+	if !rd.ready {
+		status = http.StatusServiceUnavailable
+	}
+	rd.mu.Unlock()
+
+	writeJSON(w, status, map[string]any{"ready": status == http.StatusOK, "checks": results})
+}
+
+// run executes all checks concurrently; callers hold rd.mu
+func (rd *readiness) run(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make(map[string]string, len(rd.checks))
+	ready := true
+	for name, check := range rd.checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.ErrorContext(ctx, "Readiness check failed", "check", name, "error", err)
+				results[name] = "fail"
+				ready = false
+				return
+			}
+			results[name] = "ok"
+		}(name, check)
+	}
+	wg.Wait()
+	rd.results, rd.ready, rd.checkedAt = results, ready, time.Now()
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
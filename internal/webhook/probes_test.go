@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessIgnoresProberDisconnect(t *testing.T) {
+	rd := &readiness{
+		ttl: time.Minute,
+		checks: map[string]func(ctx context.Context) error{
+			"store": func(ctx context.Context) error { return ctx.Err() },
+		},
+	}
+	// The prober has already gone away; the checks must not see its cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/readyz", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	rd.serveHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestReadinessCachesResults(t *testing.T) {
+	calls := 0
+	rd := &readiness{
+		ttl: time.Minute,
+		checks: map[string]func(ctx context.Context) error{
+			"github": func(ctx context.Context) error {
+				calls++
+				return nil
+			},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		rd.serveHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+	}
+	if calls != 1 {
+		t.Errorf("check ran %d times within the TTL, want 1", calls)
+	}
+}
@@ -6,182 +6,572 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/AobaIwaki123/dup-radar/internal/config"
 	"github.com/AobaIwaki123/dup-radar/internal/embedding"
 	ghclient "github.com/AobaIwaki123/dup-radar/internal/github"
+	"github.com/AobaIwaki123/dup-radar/internal/logging"
+	"github.com/AobaIwaki123/dup-radar/internal/metrics"
+	"github.com/AobaIwaki123/dup-radar/internal/preprocess"
+	"github.com/AobaIwaki123/dup-radar/internal/queue"
 	"github.com/AobaIwaki123/dup-radar/internal/storage"
+	"github.com/AobaIwaki123/dup-radar/internal/tracing"
 	githubapi "github.com/google/go-github/v62/github"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// Pipeline step names used for retries and checkpoints
+const (
+	stepConfig  = "config"
+	stepEmbed   = "embed"
+	stepSearch  = "search"
+	stepComment = "comment"
+	stepLabel   = "label"
+	stepInsert  = "insert"
+	stepUpdate  = "update"
+	stepSync    = "sync"
+)
+
+// checkpointedSteps have side effects and must not be repeated when a job is retried or replayed.
+// Embedding and search are re-run because their results are not persisted.
+var checkpointedSteps = map[string]bool{
+	stepComment: true,
+	stepLabel:   true,
+	stepInsert:  true,
+	stepUpdate:  true,
+	stepSync:    true,
+}
+
 // Handler handles GitHub webhooks
 type Handler struct {
-	config     *config.Config
-	ghClient   *ghclient.Client
-	bqClient   *storage.BQClient
-	signingKey []byte
+	live        *config.Live // Reloadable configuration; read it through config()
+	ghClient    *ghclient.Client
+	repoConfigs *ghclient.RepoConfigs // Cached .github/dup-radar.yml files
+	embedder    embedding.Embedder
+	store       storage.VectorStore
+	metric      storage.Metric // The store's distance metric; like the store, fixed at startup
+	queue       *queue.Queue
+	signingKey  []byte
 }
 
 // NewHandler creates a new webhook handler
-func NewHandler(cfg *config.Config, gh *ghclient.Client, bq *storage.BQClient, secret string) *Handler {
-	log.Printf("DEBUG: Creating webhook handler")
+func NewHandler(cfg *config.Live, gh *ghclient.Client, emb embedding.Embedder, store storage.VectorStore, q *queue.Queue, secret string) *Handler {
+	slog.Debug("Creating webhook handler")
 	return &Handler{
-		config:     cfg,
-		ghClient:   gh,
-		bqClient:   bq,
-		signingKey: []byte(secret),
+		live:        cfg,
+		ghClient:    gh,
+		repoConfigs: ghclient.NewRepoConfigs(gh, cfg.Get().GitHub.RepoConfigTTL, cfg.Get().GCP.VectorSearch.Distance),
+		embedder:    emb,
+		store:       store,
+		metric:      storage.ParseMetric(cfg.Get().GCP.VectorSearch.Distance),
+		queue:       q,
+		signingKey:  []byte(secret),
 	}
 }
 
 // SetupServer creates and configures an HTTP server for webhook handling
-func SetupServer(cfg *config.Config, gh *ghclient.Client, bq *storage.BQClient, secret string, port int) *http.Server {
-	log.Printf("DEBUG: Setting up HTTP server on port %d", port)
-	
-	handler := NewHandler(cfg, gh, bq, secret)
-	
+// and starts the queue workers that process accepted deliveries; cancelling ctx
+// interrupts the jobs they are running
+func SetupServer(ctx context.Context, cfg *config.Live, gh *ghclient.Client, emb embedding.Embedder, store storage.VectorStore, q *queue.Queue, secret string, port int) *http.Server {
+	slog.Debug("Setting up HTTP server", "port", port)
+
+	handler := NewHandler(cfg, gh, emb, store, q, secret)
+	q.Start(ctx, handler.ProcessJob)
+
+	sc := cfg.Get().Server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/webhook", handler.HandleWebhook)
-	
+	mux.HandleFunc(sc.Path, handler.HandleWebhook)
+	handler.registerProbes(mux)
+	handler.registerAdmin(mux)
+	mux.Handle("/metrics", metrics.Handler())
+	slog.Info("Routing webhooks", "path", sc.Path)
+
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      mux,
+		ReadTimeout:  sc.ReadTimeout,
+		WriteTimeout: sc.WriteTimeout,
+		IdleTimeout:  sc.IdleTimeout,
 	}
-	
-	log.Printf("DEBUG: HTTP server configured on port %d", port)
+
+	slog.Debug("HTTP server configured", "port", port)
 	return server
 }
 
+// config returns the current configuration, which may be replaced by a reload at any time
+func (h *Handler) config() *config.Config {
+	return h.live.Get()
+}
+
 // HandleWebhook processes GitHub webhook requests
 func (h *Handler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
-	log.Printf("DEBUG: Received webhook request from %s %s", r.RemoteAddr, r.Method)
-	
+	slog.Debug("Received webhook request", "remote_addr", r.RemoteAddr, "method", r.Method)
+
 	// Only accept POST requests
 	if r.Method != http.MethodPost {
-		log.Printf("ERROR: Received non-POST request: %s", r.Method)
+		slog.Warn("Received non-POST request", "remote_addr", r.RemoteAddr, "method", r.Method)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	
+	eventType := githubapi.WebHookType(r)
+	deliveryID := r.Header.Get("X-GitHub-Delivery")
+	ctx := logging.With(r.Context(), "delivery_id", deliveryID, "event", eventType)
+	ctx, span := tracing.Start(tracing.WithDelivery(ctx, deliveryID), "webhook.receive",
+		attribute.String("github.event", eventType),
+		attribute.String("github.delivery", deliveryID),
+	)
+	defer span.End()
+	count := func(action, outcome string) {
+		metrics.WebhookDeliveries.WithLabelValues(eventType, action, outcome).Inc()
+		span.SetAttributes(attribute.String("github.action", action), attribute.String("dupradar.outcome", outcome))
+	}
+
 	// Read payload with a size limit to prevent memory exhaustion
 	const maxSize = 5 * 1024 * 1024 // 5MB limit
 	payloadReader := io.LimitReader(r.Body, maxSize)
 	payload, err := io.ReadAll(payloadReader)
 	if err != nil {
-		log.Printf("ERROR: Failed to read request body: %v", err)
+		slog.ErrorContext(ctx, "Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		count("", metrics.OutcomeBadRequest)
 		return
 	}
-	log.Printf("DEBUG: Read %d bytes from request body", len(payload))
+	slog.DebugContext(ctx, "Read request body", "bytes", len(payload))
 
 	// Verify webhook signature
 	sig := strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
 	if sig == "" {
-		log.Printf("ERROR: Missing X-Hub-Signature-256 header")
+		slog.WarnContext(ctx, "Missing X-Hub-Signature-256 header")
 		http.Error(w, "Missing signature", http.StatusUnauthorized)
+		metrics.SignatureFailures.WithLabelValues("missing").Inc()
+		count("", metrics.OutcomeInvalidSignature)
 		return
 	}
-	
-	log.Printf("DEBUG: Checking webhook signature: %s", sig)
+
 	mac := hmac.New(sha256.New, h.signingKey)
 	mac.Write(payload)
 	expected := hex.EncodeToString(mac.Sum(nil))
 
 	if !hmac.Equal([]byte(sig), []byte(expected)) {
-		log.Printf("ERROR: Invalid webhook signature received")
+		slog.WarnContext(ctx, "Invalid webhook signature received")
 		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		metrics.SignatureFailures.WithLabelValues("invalid").Inc()
+		count("", metrics.OutcomeInvalidSignature)
 		return
 	}
-	log.Printf("DEBUG: Webhook signature verified successfully")
-
-	eventType := githubapi.WebHookType(r)
-	log.Printf("DEBUG: Webhook event type: %s", eventType)
+	slog.DebugContext(ctx, "Webhook signature verified")
 
 	event, err := githubapi.ParseWebHook(eventType, payload)
 	if err != nil {
-		log.Printf("ERROR: Failed to parse webhook payload: %v", err)
+		slog.ErrorContext(ctx, "Failed to parse webhook payload", "error", err)
 		http.Error(w, "Failed to parse webhook payload", http.StatusBadRequest)
+		count("", metrics.OutcomeBadRequest)
 		return
 	}
 
 	if evt, ok := event.(*githubapi.IssuesEvent); ok {
 		action := evt.GetAction()
-		log.Printf("DEBUG: Received issues event with action: %s", action)
-		if action == "opened" {
-			issueNumber := evt.GetIssue().GetNumber()
-			repoName := evt.GetRepo().GetFullName()
-			log.Printf("DEBUG: Processing new issue #%d from repo %s", issueNumber, repoName)
-			
-			// Use a background context for the goroutine instead of request context
-			bgCtx := context.Background()
-			go h.handleIssue(bgCtx, evt)
+		ctx = logging.With(ctx, "action", action, "repo", evt.GetRepo().GetFullName(), "issue", evt.GetIssue().GetNumber())
+		if shouldProcess(evt) {
+			slog.InfoContext(ctx, "Queueing issues event")
+			job := &queue.Job{
+				ID:      deliveryID,
+				Event:   eventType,
+				Payload: payload,
+				Keys:    []string{eventKey(evt)},
+				Serial:  issueKey(evt),
+			}
+			if err := h.queue.Enqueue(job); errors.Is(err, queue.ErrDuplicate) {
+				// Redelivery of an event that was already accepted: acknowledge without reprocessing
+				slog.InfoContext(ctx, "Delivery was already processed, skipping")
+				w.WriteHeader(http.StatusOK)
+				count(action, metrics.OutcomeDuplicate)
+				return
+			} else if err != nil {
+				slog.ErrorContext(ctx, "Failed to enqueue webhook delivery", "error", err)
+				http.Error(w, "Failed to enqueue webhook delivery", http.StatusInternalServerError)
+				count(action, metrics.OutcomeError)
+				return
+			}
+			count(action, metrics.OutcomeQueued)
 		} else {
-			log.Printf("DEBUG: Ignoring issues event with action: %s", action)
+			slog.DebugContext(ctx, "Ignoring issues event")
+			count(action, metrics.OutcomeIgnored)
 		}
+	} else if push, ok := event.(*githubapi.PushEvent); ok {
+		h.handlePush(push)
+		count("", metrics.OutcomeHandled)
 	} else {
-		log.Printf("DEBUG: Ignoring non-issues event type: %T", event)
+		slog.DebugContext(ctx, "Ignoring event", "type", fmt.Sprintf("%T", event))
+		count("", metrics.OutcomeIgnored)
 	}
 
 	w.WriteHeader(http.StatusAccepted)
-	log.Printf("DEBUG: Webhook request processed successfully")
+}
+
+// shouldProcess reports whether an issues event needs work from the pipeline
+func shouldProcess(evt *githubapi.IssuesEvent) bool {
+	switch evt.GetAction() {
+	case "opened", "closed", "reopened", "deleted", "transferred":
+		return true
+	case "edited":
+		return evt.GetChanges().GetTitle() != nil || evt.GetChanges().GetBody() != nil
+	default:
+		return false
+	}
+}
+
+// handlePush drops the cached in-repo configuration when the default branch changes
+func (h *Handler) handlePush(evt *githubapi.PushEvent) {
+	repo := evt.GetRepo()
+	if evt.GetRef() != "refs/heads/"+repo.GetDefaultBranch() {
+		slog.Debug("Ignoring push to non-default ref", "repo", repo.GetFullName(), "ref", evt.GetRef())
+		return
+	}
+	h.repoConfigs.Invalidate(repo.GetFullName())
+}
+
+// eventKey identifies an issues event independently of its delivery ID.
+// The issue's updated_at distinguishes repeated actions such as a second edit or close.
+func eventKey(evt *githubapi.IssuesEvent) string {
+	issue := evt.GetIssue()
+	return fmt.Sprintf("event:%s#%d:%s@%d", strings.ToLower(evt.GetRepo().GetFullName()),
+		issue.GetNumber(), evt.GetAction(), issue.GetUpdatedAt().Unix())
+}
+
+// issueKey identifies the issue an event is about; its jobs run one at a time
+func issueKey(evt *githubapi.IssuesEvent) string {
+	return fmt.Sprintf("%s#%d", strings.ToLower(evt.GetRepo().GetFullName()), evt.GetIssue().GetNumber())
+}
+
+// ProcessJob runs the pipeline for a queued webhook delivery.
+// Each attempt is traced as a span in the trace of the delivery.
+func (h *Handler) ProcessJob(ctx context.Context, job *queue.Job) (err error) {
+	ctx = logging.With(ctx, "delivery_id", job.ID, "event", job.Event)
+	ctx, span := tracing.Start(tracing.WithDelivery(ctx, job.ID), "webhook.process",
+		attribute.String("github.event", job.Event),
+		attribute.String("github.delivery", job.ID),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	event, err := githubapi.ParseWebHook(job.Event, job.Payload)
+	if err != nil {
+		return fmt.Errorf("parse webhook payload: %w", err)
+	}
+	evt, ok := event.(*githubapi.IssuesEvent)
+	if !ok {
+		return fmt.Errorf("unexpected event type %T", event)
+	}
+	span.SetAttributes(
+		attribute.String("github.action", evt.GetAction()),
+		attribute.String("github.repository", evt.GetRepo().GetFullName()),
+		attribute.Int("github.issue", evt.GetIssue().GetNumber()),
+	)
+	ctx = logging.With(ctx, "action", evt.GetAction(), "repo", evt.GetRepo().GetFullName(), "issue", evt.GetIssue().GetNumber())
+	// Act as the GitHub App installation that sent the event
+	ctx = ghclient.WithInstallation(ctx, evt.GetInstallation().GetID())
+
+	var rs config.RepoSettings
+	if err := h.runStep(ctx, job, stepConfig, func(ctx context.Context) (err error) {
+		rs, err = h.repoSettings(ctx, evt.GetRepo())
+		return err
+	}); err != nil {
+		return err
+	}
+	if !rs.Enabled {
+		slog.InfoContext(ctx, "DupRadar is disabled for the repository, ignoring event")
+		return nil
+	}
+
+	switch evt.GetAction() {
+	case "opened", "edited":
+		if rs.Excludes(labelNames(evt.GetIssue())) {
+			slog.InfoContext(ctx, "Issue has an excluded label, skipping")
+			return nil
+		}
+		if evt.GetAction() == "edited" {
+			return h.handleIssueEdited(ctx, job, evt, rs)
+		}
+		return h.handleIssue(ctx, job, evt, rs)
+	case "closed", "reopened", "deleted":
+		return h.handleIssueLifecycle(ctx, job, evt)
+	case "transferred":
+		var changes transferChanges
+		if err := json.Unmarshal(job.Payload, &changes); err != nil {
+			return fmt.Errorf("parse transfer changes: %w", err)
+		}
+		return h.handleIssueTransferred(ctx, job, evt, changes)
+	default:
+		slog.DebugContext(ctx, "Ignoring queued issues event")
+		return nil
+	}
+}
+
+// repoSettings resolves the effective settings for a repository: the server configuration
+// with org/repo overrides, then the repository's own .github/dup-radar.yml
+func (h *Handler) repoSettings(ctx context.Context, repo *githubapi.Repository) (config.RepoSettings, error) {
+	rs := h.config().ForRepo(repo.GetFullName())
+	if !rs.Enabled {
+		return rs, nil
+	}
+	file, err := h.repoConfigs.Get(ctx, repo.GetOwner().GetLogin(), repo.GetName())
+	if err != nil {
+		return rs, err
+	}
+	return rs.WithRepoFile(file), nil
+}
+
+// labelNames returns the names of an issue's labels
+func labelNames(issue *githubapi.Issue) []string {
+	names := make([]string, 0, len(issue.Labels))
+	for _, l := range issue.Labels {
+		names = append(names, l.GetName())
+	}
+	return names
+}
+
+// runStep runs one pipeline step with retries and exponential backoff.
+// Side-effecting steps are checkpointed and skipped if an earlier attempt of the job completed them.
+func (h *Handler) runStep(ctx context.Context, job *queue.Job, step string, fn func(ctx context.Context) error) error {
+	ctx = logging.With(ctx, "step", step)
+	if job.Done(step) {
+		slog.DebugContext(ctx, "Step already completed, skipping")
+		return nil
+	}
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "step."+step)
+	err := h.queue.Retry(ctx, step, fn)
+	tracing.End(span, err)
+	if err != nil {
+		// The job's failure is logged once it is dead-lettered
+		slog.DebugContext(ctx, "Step failed", "duration", time.Since(start), "error", err)
+		return err
+	}
+	slog.DebugContext(ctx, "Step completed", "duration", time.Since(start))
+	if checkpointedSteps[step] {
+		if err := h.queue.Checkpoint(job, step); err != nil {
+			slog.ErrorContext(ctx, "Failed to checkpoint step", "error", err)
+		}
+	}
+	return nil
 }
 
 // handleIssue processes new GitHub issues
-func (h *Handler) handleIssue(ctx context.Context, evt *githubapi.IssuesEvent) {
+func (h *Handler) handleIssue(ctx context.Context, job *queue.Job, evt *githubapi.IssuesEvent, rs config.RepoSettings) error {
 	repoFull := evt.GetRepo().GetFullName()
 	issue := evt.GetIssue()
 	issueNumber := issue.GetNumber()
-	log.Printf("DEBUG: Processing issue #%d from repo %s", issueNumber, repoFull)
-
-	text := issue.GetTitle() + "\n" + issue.GetBody()
-	textLength := len(text)
-	log.Printf("DEBUG: Combined text length for embedding: %d characters", textLength)
+	slog.InfoContext(ctx, "Processing new issue")
 
 	// 1) Embed
-	log.Printf("DEBUG: [Issue #%d] Creating text embedding", issueNumber)
-	vec, err := embedding.CreateEmbedding(ctx, h.config, text)
-	if err != nil {
-		log.Printf("ERROR: Failed to create embedding for issue #%d: %v", issueNumber, err)
-		return
+	var vecs issueVectors
+	if err := h.runStep(ctx, job, stepEmbed, func(ctx context.Context) (err error) {
+		vecs, err = h.embedIssue(ctx, issue)
+		return err
+	}); err != nil {
+		return err
 	}
-	log.Printf("DEBUG: [Issue #%d] Successfully created embedding with %d dimensions", issueNumber, len(vec))
 
 	// 2) Search similar
-	log.Printf("DEBUG: [Issue #%d] Searching for similar issues (top %d)", issueNumber, h.config.GitHub.TopK)
-	ids, dists, err := h.bqClient.SearchSimilarIssues(ctx, vec, h.config.GitHub.TopK)
-	if err != nil {
-		log.Printf("ERROR: BigQuery search failed for issue #%d: %v", issueNumber, err)
-	} else {
-		log.Printf("DEBUG: [Issue #%d] Found %d similar issues", issueNumber, len(ids))
-		for i := 0; i < len(ids) && i < len(dists); i++ {
-			log.Printf("DEBUG: [Issue #%d] Similar issue #%d with distance %.4f", issueNumber, ids[i], dists[i])
-		}
+	var similar []storage.SimilarIssue
+	if err := h.runStep(ctx, job, stepSearch, func(ctx context.Context) (err error) {
+		similar, err = h.searchSimilar(ctx, rs, repoFull, issueNumber, vecs.query)
+		return err
+	}); err != nil {
+		return err
 	}
 
-	// 3) Comment if similar found
-	log.Printf("DEBUG: [Issue #%d] Building comment with similarity threshold %.4f", issueNumber, h.config.GitHub.Similarity)
-	if msg := ghclient.BuildSimilarIssuesComment(h.config.GitHub.Similarity, ids, dists); msg != "" {
+	// 3) Comment (and label) if similar found
+	slog.DebugContext(ctx, "Building comment", "similarity", rs.Similarity, "candidates", len(similar))
+	if msg := h.buildComment(rs, repoFull, similar); msg != "" {
 		owner := evt.GetRepo().GetOwner().GetLogin()
 		repo := evt.GetRepo().GetName()
-		log.Printf("DEBUG: [Issue #%d] Posting comment to %s/%s#%d", issueNumber, owner, repo, issueNumber)
-		if err := h.ghClient.CreateIssueComment(ctx, owner, repo, issue.GetNumber(), msg); err != nil {
-			log.Printf("ERROR: Failed to create comment on issue #%d: %v", issueNumber, err)
-		} else {
-			log.Printf("DEBUG: [Issue #%d] Successfully posted comment", issueNumber)
+		if err := h.runStep(ctx, job, stepComment, func(ctx context.Context) error {
+			return h.createComment(ctx, owner, repo, issue.GetNumber(), msg)
+		}); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Posted similar issues comment")
+		if err := h.labelIssue(ctx, job, evt, rs); err != nil {
+			return err
 		}
 	} else {
-		log.Printf("DEBUG: [Issue #%d] No similar issues found above threshold, skipping comment", issueNumber)
+		slog.InfoContext(ctx, "No similar issues found above threshold, skipping comment")
 	}
 
 	// 4) Insert vector
-	log.Printf("DEBUG: [Issue #%d] Storing embedding in BigQuery", issueNumber)
-	if err := h.bqClient.InsertIssueVector(ctx, issue, repoFull, vec); err != nil {
-		log.Printf("ERROR: Failed to store embedding for issue #%d: %v", issueNumber, err)
-		return
+	if err := h.runStep(ctx, job, stepInsert, func(ctx context.Context) error {
+		return h.insertVector(ctx, issue, repoFull, vecs.document)
+	}); err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Stored embedding in vector store")
+	return nil
+}
+
+// labelIssue adds the configured labels to an issue that received similar-issue candidates
+func (h *Handler) labelIssue(ctx context.Context, job *queue.Job, evt *githubapi.IssuesEvent, rs config.RepoSettings) error {
+	if len(rs.Labels) == 0 {
+		return nil
+	}
+	owner := evt.GetRepo().GetOwner().GetLogin()
+	repo := evt.GetRepo().GetName()
+	issueNumber := evt.GetIssue().GetNumber()
+	return h.runStep(ctx, job, stepLabel, func(ctx context.Context) error {
+		return h.ghClient.AddLabels(ctx, owner, repo, issueNumber, rs.Labels)
+	})
+}
+
+// buildComment builds the similar-issues comment for an issue in repoFull,
+// or returns "" if no candidate is within the threshold
+func (h *Handler) buildComment(rs config.RepoSettings, repoFull string, similar []storage.SimilarIssue) string {
+	opts := ghclient.CommentOptions{
+		DotProduct: h.metric == storage.MetricDotProduct,
+		Threshold:  rs.Similarity,
+		Repo:       repoFull,
+		Language:   rs.CommentLanguage,
+		Template:   rs.CommentTemplate,
+	}
+	candidates := make([]ghclient.Candidate, len(similar))
+	for i, is := range similar {
+		candidates[i] = ghclient.Candidate{
+			Repo:        is.Repo,
+			Number:      is.IssueID,
+			Score:       h.metric.Score(is.Distance),
+			Closed:      is.Closed(),
+			StateReason: is.StateReason,
+		}
+	}
+	return ghclient.BuildSimilarIssuesComment(opts, candidates)
+}
+
+// issueVectors are the per-chunk embeddings of an issue: query is searched with, document is stored
+type issueVectors struct {
+	query    [][]float64
+	document []storage.Chunk
+}
+
+// embedIssue preprocesses an issue, splits its body into chunks and creates the query and
+// document embeddings of every chunk, each with the title, with the configured task types in
+// as few requests as possible. If a chunk's query and document inputs are identical, one
+// embedding serves as both.
+func (h *Handler) embedIssue(ctx context.Context, issue *githubapi.Issue) (issueVectors, error) {
+	cfg := h.config()
+	ec := cfg.Embedding
+	title, body := preprocess.Issue(cfg, issue.GetTitle(), issue.GetBody())
+	slog.DebugContext(ctx, "Preprocessed issue text", "characters", len(issue.GetTitle())+len(issue.GetBody()), "normalized_characters", len(title)+len(body))
+	chunks := embedding.Chunk(body, ec.Chunking.Size, ec.Chunking.Overlap)
+	if len(chunks) > ec.Chunking.MaxChunks {
+		slog.WarnContext(ctx, "Issue body exceeds the chunk limit, embedding only the first chunks", "chunks", len(chunks), "max_chunks", ec.Chunking.MaxChunks)
+		chunks = chunks[:ec.Chunking.MaxChunks]
+	}
+	metrics.EmbeddingChunks.Observe(float64(len(chunks)))
+
+	// A query and a document input per chunk; the embedder sends inputs that are the same
+	// for the provider (e.g. one that ignores task types) only once
+	inputs := make([]embedding.Input, 0, 2*len(chunks))
+	for _, chunk := range chunks {
+		text := title + "\n" + chunk
+		query := embedding.Input{Text: text, TaskType: embedding.TaskType(strings.ToUpper(ec.QueryTaskType))}
+		doc := embedding.Input{Text: text, TaskType: embedding.TaskType(strings.ToUpper(ec.DocumentTaskType))}
+		if ec.TitleField && chunk != "" {
+			doc.Text, doc.Title = chunk, title
+		}
+		inputs = append(inputs, query, doc)
+	}
+
+	slog.DebugContext(ctx, "Creating text embeddings", "chunks", len(chunks), "inputs", len(inputs),
+		"query_task_type", ec.QueryTaskType, "document_task_type", ec.DocumentTaskType)
+	results, err := h.embedder.EmbedBatch(ctx, inputs)
+	if err != nil {
+		return issueVectors{}, err
+	}
+	vecs := issueVectors{query: make([][]float64, len(chunks)), document: make([]storage.Chunk, len(chunks))}
+	for i, chunk := range chunks {
+		vecs.query[i] = results[2*i].Embedding
+		vecs.document[i] = storage.Chunk{Text: chunk, Embedding: results[2*i+1].Embedding}
+	}
+	return vecs, nil
+}
+
+// searchSimilar finds the issues most similar to any of the query vectors in the search
+// scope of repoFull, excluding the issue itself
+func (h *Handler) searchSimilar(ctx context.Context, rs config.RepoSettings, repoFull string, issueNumber int, vecs [][]float64) ([]storage.SimilarIssue, error) {
+	repos := rs.SearchRepos
+	topK := rs.TopK
+	slog.DebugContext(ctx, "Searching for similar issues", "top_k", topK, "repos", repos)
+	cfg := h.config()
+	closedMode := strings.ToLower(cfg.GitHub.ClosedIssues)
+	// Fetch one extra hit in case the issue itself is already stored
+	start := time.Now()
+	found, err := h.store.SearchSimilarIssues(ctx, vecs, storage.SearchOptions{
+		Repos:     repos,
+		TopK:      topK + 1,
+		OpenOnly:  closedMode == "exclude",
+		MaxChunks: cfg.Embedding.Chunking.MaxChunks,
+		Aggregate: cfg.Embedding.Chunking.Aggregate,
+	})
+	metrics.SearchDuration.WithLabelValues(strings.ToLower(cfg.Storage.Backend), metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	similar := make([]storage.SimilarIssue, 0, len(found))
+	for _, s := range found {
+		if strings.EqualFold(s.Repo, repoFull) && s.IssueID == int64(issueNumber) {
+			continue
+		}
+		if len(similar) == topK {
+			break
+		}
+		similar = append(similar, s)
+		metrics.SearchDistances.WithLabelValues(string(h.metric)).Observe(s.Distance)
+		slog.DebugContext(ctx, "Similar issue candidate", "candidate", fmt.Sprintf("%s#%d", s.Repo, s.IssueID), "state", s.State, "distance", s.Distance)
+	}
+	if closedMode == "prefer_open" {
+		sort.SliceStable(similar, func(i, j int) bool { return !similar[i].Closed() && similar[j].Closed() })
+	}
+	slog.DebugContext(ctx, "Found similar issues", "count", len(similar))
+	return similar, nil
+}
+
+// createComment posts a new similar-issues comment
+func (h *Handler) createComment(ctx context.Context, owner, repo string, issueNumber int, msg string) error {
+	if err := h.ghClient.CreateIssueComment(ctx, owner, repo, issueNumber, msg); err != nil {
+		return err
+	}
+	metrics.CommentsPosted.WithLabelValues("created").Inc()
+	return nil
+}
+
+// insertVector stores (or replaces) the chunks of an issue, counting failed attempts
+func (h *Handler) insertVector(ctx context.Context, issue *githubapi.Issue, repoFull string, chunks []storage.Chunk) error {
+	err := h.store.InsertIssueVector(ctx, issue, repoFull, chunks)
+	if err != nil {
+		metrics.InsertFailures.WithLabelValues(strings.ToLower(h.config().Storage.Backend)).Inc()
+	}
+	return err
+}
+
+// updateVector replaces the chunks of an edited issue, keeping its stored state and creation
+// time. An issue that was never indexed, e.g. one opened before DupRadar was installed, is inserted.
+func (h *Handler) updateVector(ctx context.Context, issue *githubapi.Issue, repoFull string, chunks []storage.Chunk) error {
+	err := h.store.UpdateIssueVector(ctx, issue, repoFull, chunks)
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.InfoContext(ctx, "Edited issue is not indexed yet, inserting it")
+		return h.insertVector(ctx, issue, repoFull, chunks)
+	}
+	if err != nil {
+		metrics.InsertFailures.WithLabelValues(strings.ToLower(h.config().Storage.Backend)).Inc()
 	}
-	log.Printf("DEBUG: [Issue #%d] Successfully stored embedding", issueNumber)
+	return err
 }